@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"suggestion/suggest"
+)
+
+// Reload reloads the data file, or swaps in the dataset sent in the body if
+// there is one.
+func Reload(fname string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			writeBindError(w, err)
+			return
+		}
+
+		start := time.Now()
+		if len(body) > 0 {
+			store, ok := suggestions.(pusher)
+			if !ok {
+				writeError(w, http.StatusNotImplemented, fmt.Errorf("the store doesn't support pushed data"))
+				return
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err := store.LoadFrom(bytes.NewReader(body), mediaType == "text/csv"); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			reloaded()
+		} else if err := load(fname); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, ReloadResponse{
+			Keys:     suggestions.Len(),
+			Duration: time.Since(start).String(),
+		})
+	}
+}
+
+// pusher is implemented by stores that can load a dataset from a request
+// body rather than from the data file.
+type pusher interface {
+	LoadFrom(r io.Reader, csv bool) error
+}
+
+// Validate dry-runs a data file sent in the body against the loading options
+// without touching the live index.
+func Validate(opts suggest.MapOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			writeBindError(w, err)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		writeJSON(w, http.StatusOK, suggest.Validate(bytes.NewReader(body), mediaType == "text/csv", opts))
+	}
+}
+
+func Stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, suggestions.Stats())
+}
+
+func Key(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	items := suggestions.Items(r.Context(), id)
+	if items == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no suggestions stored under %q", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, KeyResponse{Key: items[0].Key, Items: items})
+}
+
+const (
+	defaultKeysLimit = 100
+	// caps a page so that the keyspace isn't dumped by accident
+	maxKeysLimit = 1000
+)
+
+// Keys lists the stored keys with the prefix query parameter, a page at a
+// time.
+func Keys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs ValidationError
+	offset, err := queryInt(q, "offset")
+	errs.add("offset", err)
+	limit, err := queryInt(q, "limit")
+	errs.add("limit", err)
+
+	from, size := 0, defaultKeysLimit
+	if offset != nil {
+		from = *offset
+	}
+	if limit != nil {
+		size = *limit
+	}
+
+	if from < 0 {
+		errs.add("offset", fmt.Errorf("offset must not be negative"))
+	}
+
+	if size < 1 {
+		errs.add("limit", fmt.Errorf("limit must be positive"))
+	}
+
+	if err := errs.err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	keys, total := suggestions.Keys(r.Context(), q.Get("prefix"), from, min(size, maxKeysLimit))
+	writeJSON(w, http.StatusOK, KeysResponse{Keys: keys, Total: total})
+}
+
+// snapshotter is implemented by stores that can be dumped and restored, the
+// Redis store persists its data anyway.
+type snapshotter interface {
+	Snapshot(w io.Writer) error
+	LoadSnapshot(path string) error
+}
+
+func Snapshot(w http.ResponseWriter, r *http.Request) {
+	store, ok := suggestions.(snapshotter)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("the store doesn't support snapshots"))
+		return
+	}
+
+	if !suggestions.Loaded() {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("no data loaded"))
+		return
+	}
+
+	// big indexes take longer than -write-timeout to send
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+
+	setContentType(w, binaryType)
+	w.Header().Set("Content-Disposition", `attachment; filename="suggestions.snapshot"`)
+	if err := store.Snapshot(w); err != nil {
+		// the status is already sent, the client sees a truncated stream
+		log.Println(err)
+	}
+}
+
+const (
+	minReloadInterval = time.Second
+	maxReloadInterval = 24 * time.Hour
+)
+
+func ReloadInterval(w http.ResponseWriter, r *http.Request) {
+	obj := new(ReloadIntervalRequest)
+	if err := bind(http.MaxBytesReader(w, r.Body, maxBody), obj); err != nil {
+		writeBindError(w, err)
+		return
+	}
+
+	interval, err := time.ParseDuration(obj.Interval)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if interval < minReloadInterval || interval > maxReloadInterval {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("interval must be between %v and %v", minReloadInterval, maxReloadInterval))
+		return
+	}
+
+	previous := setReloadPeriod(interval)
+	writeJSON(w, http.StatusOK, ReloadIntervalResponse{
+		Interval: interval.String(),
+		Previous: previous.String(),
+	})
+}
+
+func withToken(f http.HandlerFunc, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		f.ServeHTTP(w, r)
+	}
+}
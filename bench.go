@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"suggestion/suggest"
+)
+
+type benchResult struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+// benchmark fires suggest requests built from a wordlist at a running
+// instance and reports latency percentiles and throughput. Responses are
+// decoded into the API models, so malformed ones count as errors.
+func benchmark(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("suggestion benchmark", flag.ContinueOnError)
+	target := fs.String("url", "http://localhost:8080/v2/api/suggest", "suggest endpoint to load")
+	words := fs.String("words", "", "file with one input per line, required")
+	requests := fs.Int("requests", 1000, "total number of requests")
+	concurrency := fs.Int("concurrency", 10, "number of requests in flight")
+	limit := fs.Int("limit", 0, "limit sent with every request, 0 leaves the server default")
+	timeout := fs.Duration("timeout", 5*time.Second, "per-request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *words == "" {
+		return fmt.Errorf("-words is required")
+	}
+
+	if *requests < 1 || *concurrency < 1 {
+		return fmt.Errorf("-requests and -concurrency must be positive")
+	}
+
+	inputs, err := readWords(*words)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+
+	var next atomic.Int64
+	results := make([]benchResult, *requests)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := int(next.Add(1)) - 1
+				if n >= *requests {
+					return
+				}
+
+				req := SuggestionRequest{Input: Input{inputs[n%len(inputs)]}}
+				if *limit > 0 {
+					req.Limit = limit
+				}
+				results[n] = benchRequest(client, *target, &req)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report(out, results, time.Since(start))
+	return nil
+}
+
+func readWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words = append(words, word)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%s: no words", path)
+	}
+
+	return words, nil
+}
+
+func benchRequest(client *http.Client, target string, obj *SuggestionRequest) benchResult {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return benchResult{err: err}
+	}
+
+	start := time.Now()
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return benchResult{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	result := benchResult{latency: time.Since(start), status: resp.StatusCode, err: err}
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return result
+	}
+
+	// /v1 answers a bare list, /v2 wraps it along with the total
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var list []suggest.Suggestion
+		result.err = json.Unmarshal(data, &list)
+	} else {
+		var obj SuggestionsResponse
+		result.err = json.Unmarshal(data, &obj)
+	}
+
+	return result
+}
+
+func report(w io.Writer, results []benchResult, elapsed time.Duration) {
+	statuses := make(map[int]int)
+	latencies := make([]time.Duration, 0, len(results))
+	errs := 0
+	for _, r := range results {
+		if r.err != nil {
+			errs++
+			continue
+		}
+
+		statuses[r.status]++
+		latencies = append(latencies, r.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Fprintf(w, "requests:   %d in %v\n", len(results), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput: %.1f req/s\n", float64(len(results))/elapsed.Seconds())
+	fmt.Fprintf(w, "errors:     %d\n", errs)
+
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "status %d: %d\n", code, statuses[code])
+	}
+
+	if len(latencies) == 0 {
+		return
+	}
+
+	for _, p := range []float64{50, 95, 99} {
+		fmt.Fprintf(w, "p%v:        %v\n", p, percentile(latencies, p))
+	}
+	fmt.Fprintf(w, "max:        %v\n", latencies[len(latencies)-1])
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
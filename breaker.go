@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// breaker backs off polling reloads once threshold loads in a row have
+// failed, doubling the wait after every further failure up to maxBackoff,
+// so that a broken disk isn't hammered and the log only tells when reloads
+// start and stop failing.
+type breaker struct {
+	threshold  int
+	maxBackoff time.Duration
+	failures   int
+}
+
+func (b *breaker) open() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+// delay returns the wait before the next reload when polling every period.
+func (b *breaker) delay(period time.Duration) time.Duration {
+	if !b.open() {
+		return period
+	}
+
+	delay := period
+	for i := b.threshold; i <= b.failures && delay < b.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return max(min(delay, b.maxBackoff), period)
+}
+
+func (b *breaker) record(err error) {
+	if err == nil {
+		if b.open() {
+			log.Printf("reloads recovered after %d failures", b.failures)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.open() && b.failures == b.threshold {
+		log.Printf("%d reloads failed in a row, backing off up to %v until one succeeds", b.failures, b.maxBackoff)
+	}
+}
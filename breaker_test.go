@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerDelay(t *testing.T) {
+	b := breaker{threshold: 3, maxBackoff: time.Hour}
+	fail := errors.New("no such file")
+
+	// closed until threshold failures in a row, then doubling up to the cap
+	want := []time.Duration{
+		time.Minute, time.Minute, time.Minute,
+		2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute,
+		time.Hour, time.Hour,
+	}
+	for i, w := range want {
+		if got := b.delay(time.Minute); got != w {
+			t.Fatalf("after %d failures: delay %v, want %v", i, got, w)
+		}
+		b.record(fail)
+	}
+
+	b.record(nil)
+	if got := b.delay(time.Minute); got != time.Minute {
+		t.Errorf("after a success: delay %v, want the period", got)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := breaker{threshold: 0, maxBackoff: time.Hour}
+	for i := 0; i < 10; i++ {
+		b.record(errors.New("no such file"))
+	}
+
+	if got := b.delay(time.Minute); got != time.Minute {
+		t.Errorf("delay %v, want the period", got)
+	}
+}
+
+func TestBreakerBackoffBelowPeriod(t *testing.T) {
+	b := breaker{threshold: 1, maxBackoff: time.Second}
+	b.record(errors.New("no such file"))
+
+	if got := b.delay(time.Minute); got != time.Minute {
+		t.Errorf("delay %v, want no less than the period", got)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+
+	"suggestion/suggest"
+)
+
+const envPrefix = "SUGGEST_"
+
+type Config struct {
+	File          string
+	SnapshotLoad  string
+	Locales       []string
+	DefaultLocale string
+	AllowEmpty    bool
+	Period        time.Duration
+	Watch         bool
+	Debounce      time.Duration
+
+	BreakerThreshold  int
+	BreakerMaxBackoff time.Duration
+
+	Port       int
+	TLSCert    string
+	TLSKey     string
+	Timeout    time.Duration
+	MinTimeout time.Duration
+	Grace      time.Duration
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	Rate           float64
+	Burst          int
+	TrustForwarded bool
+	Gzip           bool
+	GzipMinSize    int
+	LogFormat      LogFormat
+	CORSOrigins    []string
+	AllowJSONP     bool
+	MsgpackType    string
+	NDJSONType     string
+
+	RedisAddr   string
+	RedisPrefix string
+	ReloadToken string
+	Pprof       bool
+
+	SlowThreshold time.Duration
+
+	Limit            int
+	MaxLimit         int
+	MinInput         int
+	MaxBody          int64
+	MaxResponseBytes int
+	DidYouMeanBelow  int
+	DidYouMeanMax    int
+	MaxBatch         int
+	EmptyAs404       bool
+	AllowEmptyInput  bool
+	HighlightPre     string
+	HighlightPost    string
+
+	Map suggest.MapOptions
+}
+
+// loadConfig resolves every setting from, in order of precedence, command
+// line args, SUGGEST_* environment variables, the -config file and the
+// defaults. The variable for a flag is its name upper-cased with dashes
+// replaced by underscores, e.g. SUGGEST_MAX_LIMIT for -max-limit, and the
+// config file uses flag names as keys.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	var cfg Config
+	def := suggest.DefaultOptions()
+	var timeoutSec int
+	var configFile, logFormat, corsOrigins, matchMode, sortOrder, csvDelimiter, reloadMode, fieldMap, conflictPolicy, tieBreak, locales string
+
+	fs := flag.NewFlagSet("suggestion", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "YAML or JSON file with settings keyed by flag name")
+	fs.StringVar(&cfg.File, "file", "suggestions.json", "file with suggestions data, or a comma-separated list of files and glob patterns")
+	fs.StringVar(&locales, "locales", "", "comma-separated locales loaded from -file with the locale before the extension, e.g. suggestions.en.json, and chosen by Accept-Language")
+	fs.StringVar(&cfg.DefaultLocale, "default-locale", "", "locale for requests accepting none of -locales, the first one by default")
+	fs.StringVar(&cfg.SnapshotLoad, "snapshot-load", "", "start from a snapshot taken by /admin/snapshot instead of parsing -file, falling back to -file if it fails")
+	fs.BoolVar(&cfg.AllowEmpty, "allow-empty", false, "start even if the file can't be loaded instead of exiting")
+	fs.DurationVar(&cfg.Period, "period", 15*time.Minute, "updating period, e.g. 30s or 15m")
+	fs.StringVar(&reloadMode, "reload-mode", string(def.Reload), "how a reload applies the file: replace|merge")
+	fs.BoolVar(&cfg.Watch, "watch", false, "reload the file on change instead of polling")
+	fs.IntVar(&cfg.BreakerThreshold, "reload-breaker-threshold", 3, "consecutive failed reloads after which polling backs off, 0 never backs off")
+	fs.DurationVar(&cfg.BreakerMaxBackoff, "reload-max-backoff", time.Hour, "longest wait between reloads while they keep failing")
+	fs.DurationVar(&cfg.Debounce, "debounce", 500*time.Millisecond, "delay coalescing rapid file changes in watch mode")
+	fs.IntVar(&cfg.Port, "port", 8080, "listening port")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "TLS certificate file, serves HTTPS together with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "TLS private key file, serves HTTPS together with -tls-cert")
+	fs.IntVar(&timeoutSec, "timeout", 2, "request timeout in seconds")
+	fs.DurationVar(&cfg.MinTimeout, "min-timeout", 50*time.Millisecond, "shortest request timeout clients may ask for with X-Timeout-Ms or timeout_ms")
+	fs.DurationVar(&cfg.Grace, "grace", 10*time.Second, "graceful shutdown period")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 5*time.Second, "maximum duration for reading a whole request, 0 disables")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 2*time.Second, "maximum duration for reading request headers, 0 disables")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 10*time.Second, "maximum duration for writing a response, should exceed -timeout, 0 disables")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", time.Minute, "maximum keep-alive idle time, 0 disables")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "per-client request rate limit in requests/sec, 0 disables")
+	fs.IntVar(&cfg.Burst, "burst", 10, "per-client request burst size")
+	fs.BoolVar(&cfg.TrustForwarded, "trust-forwarded", false, "take the client IP from X-Forwarded-For")
+	fs.BoolVar(&cfg.Gzip, "gzip", true, "gzip responses for clients accepting it")
+	fs.IntVar(&cfg.GzipMinSize, "gzip-min-size", 1024, "minimum response size in bytes worth compressing")
+	fs.StringVar(&logFormat, "log-format", string(LogText), "access log format: text|json")
+	fs.StringVar(&corsOrigins, "cors-origins", "", "comma-separated origins allowed to call the suggest API, * for any; CORS is disabled if empty")
+	fs.BoolVar(&cfg.AllowJSONP, "allow-jsonp", false, "wrap GET suggest responses in the callback query parameter for JSONP clients")
+	fs.StringVar(&cfg.MsgpackType, "msgpack-content-type", defaultMsgpackType, "Content-Type of MessagePack responses")
+	fs.StringVar(&cfg.NDJSONType, "ndjson-content-type", defaultNDJSONType, "Content-Type of NDJSON responses")
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", "", "keep suggestions in Redis at this address instead of in memory")
+	fs.StringVar(&cfg.RedisPrefix, "redis-prefix", "suggest", "prefix of the Redis keys")
+	fs.StringVar(&cfg.ReloadToken, "reload-token", "", "token for admin endpoints, admin endpoints are disabled if empty")
+	fs.DurationVar(&cfg.SlowThreshold, "slow-threshold", 0, "log queries taking longer than this to match, 0 disables")
+	fs.BoolVar(&cfg.Pprof, "pprof", false, "serve profiling endpoints under /debug/pprof/")
+	fs.StringVar(&matchMode, "match-mode", string(def.Mode), "default matching mode: exact|prefix|substring")
+	fs.BoolVar(&cfg.Map.SubstringIndex, "substring-index", def.SubstringIndex, "keep a trigram index for substring matching, trading memory for speed")
+	fs.BoolVar(&cfg.Map.Phonetic, "phonetic", false, "also match Latin-script keys sounding like the input (Metaphone), ranked below textual matches")
+	fs.BoolVar(&cfg.Map.CaseInsensitive, "case-insensitive", def.CaseInsensitive, "ignore case when matching keys")
+	fs.BoolVar(&cfg.Map.FoldDiacritics, "fold-diacritics", false, "ignore diacritics when matching keys")
+	fs.StringVar(&conflictPolicy, "conflict-policy", string(def.Conflicts), "how to treat items repeating an id and name: append|keep-first|keep-highest-cost|sum-cost")
+	fs.StringVar(&fieldMap, "field-map", "", "JSON file renaming data file fields, e.g. {\"id\": \"product_id\"}")
+	fs.StringVar(&cfg.Map.Synonyms, "synonyms", "", "JSON file with query synonyms, reloaded along with the data")
+	fs.StringVar(&csvDelimiter, "csv-delimiter", string(def.CSVDelimiter), "field delimiter for .csv data files, e.g. \\t for TSV")
+	fs.StringVar(&sortOrder, "sort", string(def.Sort), "ranking order by score: asc|desc")
+	fs.StringVar(&tieBreak, "tie-break", string(def.TieBreak), "field ordering suggestions of equal score: name|id")
+	fs.Float64Var(&cfg.Map.Weights.Cost, "weight-cost", def.Weights.Cost, "weight of the cost in the ranking score")
+	fs.Float64Var(&cfg.Map.Weights.Length, "weight-length", def.Weights.Length, "weight of the text length in characters in the ranking score")
+	fs.BoolVar(&cfg.Map.Sampling, "weighted-sampling", false, "order suggestions by random sampling weighted by cost instead of by rank, for ranking experiments")
+	fs.Int64Var(&cfg.Map.SamplingSeed, "sampling-seed", 0, "seed of -weighted-sampling for reproducible orders, 0 seeds randomly")
+	fs.IntVar(&cfg.Map.PositionBase, "position-base", 0, "position of the top suggestion, 0 or 1")
+	fs.IntVar(&cfg.Map.MaxKeys, "max-keys", 0, "refuse to load data with more keys than this, 0 disables")
+	fs.IntVar(&cfg.Map.CacheSize, "cache-size", 0, "number of query results kept in an LRU cache, 0 disables")
+	fs.StringVar(&cfg.Map.WarmPrefixes, "warm-prefixes", "", "file with popular inputs, one per line, cached on every load; requires -cache-size")
+	fs.IntVar(&cfg.Map.FuzzyDistance, "fuzzy-distance", 0, "max edit distance for typo-tolerant fallback, 0 disables")
+	fs.BoolVar(&cfg.Map.FuzzyPrefix.Enabled, "fuzzy-prefix", false, "match the fuzzy fallback against key prefixes, weighing early typos more")
+	fs.Float64Var(&cfg.Map.FuzzyPrefix.FirstChar, "fuzzy-first-char-weight", def.FuzzyPrefix.FirstChar, "cost of an edit at the first character with -fuzzy-prefix, decaying to 1 for later ones")
+	fs.IntVar(&cfg.Limit, "limit", 10, "default number of returned suggestions")
+	fs.IntVar(&cfg.MaxLimit, "max-limit", 100, "maximum number of returned suggestions")
+	fs.IntVar(&cfg.MinInput, "min-input", 1, "minimum input length in characters")
+	fs.StringVar(&cfg.HighlightPre, "highlight-pre", "<b>", "marker inserted before highlighted matches")
+	fs.StringVar(&cfg.HighlightPost, "highlight-post", "</b>", "marker inserted after highlighted matches")
+	fs.BoolVar(&cfg.AllowEmptyInput, "allow-empty-input", false, "answer empty input with the top ranked suggestions instead of an error")
+	fs.BoolVar(&cfg.EmptyAs404, "empty-as-404", false, "respond 404 instead of 200 with an empty list when nothing matches")
+	fs.IntVar(&cfg.MaxBatch, "max-batch", 10, "maximum number of inputs in a batch request")
+	fs.Int64Var(&cfg.MaxBody, "max-body", 1<<20, "maximum request body size in bytes")
+	fs.IntVar(&cfg.DidYouMeanBelow, "did-you-mean-below", 0, "offer corrected queries as did_you_mean when fewer suggestions match; requires -fuzzy-distance, 0 disables")
+	fs.IntVar(&cfg.DidYouMeanMax, "did-you-mean-max", 1, "maximum number of corrected queries offered")
+	fs.IntVar(&cfg.MaxResponseBytes, "max-response-bytes", 0, "cap on the JSON size of suggest responses, dropping the lowest ranked suggestions beyond it; 0 disables")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if err := applyEnv(fs, lookupEnv); err != nil {
+		return cfg, err
+	}
+
+	if configFile != "" {
+		if err := applyFile(fs, configFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	var err error
+	if cfg.Map.Mode, err = suggest.ParseMatchMode(matchMode); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Map.Sort, err = suggest.ParseSortOrder(sortOrder); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Map.TieBreak, err = suggest.ParseTieBreak(tieBreak); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Map.Reload, err = suggest.ParseReloadMode(reloadMode); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Map.Conflicts, err = suggest.ParseConflictPolicy(conflictPolicy); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Map.CSVDelimiter, err = suggest.ParseDelimiter(csvDelimiter); err != nil {
+		return cfg, err
+	}
+
+	if cfg.LogFormat, err = parseLogFormat(logFormat); err != nil {
+		return cfg, err
+	}
+
+	if fieldMap != "" {
+		if cfg.Map.Fields, err = suggest.LoadFieldMap(fieldMap); err != nil {
+			return cfg, err
+		}
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+
+	if cfg.RedisAddr != "" && cfg.Map.WarmPrefixes != "" {
+		return cfg, fmt.Errorf("-warm-prefixes isn't supported with -redis-addr")
+	}
+
+	if cfg.Map.WarmPrefixes != "" && cfg.Map.CacheSize <= 0 {
+		return cfg, fmt.Errorf("-warm-prefixes requires -cache-size")
+	}
+
+	if cfg.Map.PositionBase != 0 && cfg.Map.PositionBase != 1 {
+		return cfg, fmt.Errorf("-position-base must be 0 or 1")
+	}
+
+	if cfg.DidYouMeanBelow > 0 && cfg.Map.FuzzyDistance <= 0 {
+		return cfg, fmt.Errorf("-did-you-mean-below requires -fuzzy-distance")
+	}
+
+	if cfg.DidYouMeanMax < 1 {
+		return cfg, fmt.Errorf("-did-you-mean-max must be at least 1")
+	}
+
+	if cfg.RedisAddr != "" && cfg.Map.Mode == suggest.MatchSubstring {
+		return cfg, fmt.Errorf("-match-mode substring isn't supported with -redis-addr")
+	}
+
+	if cfg.RedisAddr != "" && cfg.Map.FuzzyDistance > 0 {
+		return cfg, fmt.Errorf("-fuzzy-distance isn't supported with -redis-addr")
+	}
+
+	if cfg.RedisAddr != "" && cfg.Map.Phonetic {
+		return cfg, fmt.Errorf("-phonetic isn't supported with -redis-addr")
+	}
+
+	if cfg.RedisAddr != "" && cfg.Map.CacheSize > 0 {
+		return cfg, fmt.Errorf("-cache-size isn't supported with -redis-addr")
+	}
+
+	if cfg.RedisAddr != "" && cfg.AllowEmptyInput {
+		return cfg, fmt.Errorf("-allow-empty-input isn't supported with -redis-addr")
+	}
+
+	if cfg.BreakerThreshold < 0 {
+		return cfg, fmt.Errorf("-reload-breaker-threshold must not be negative")
+	}
+
+	if cfg.Map.FuzzyPrefix.FirstChar < 1 {
+		return cfg, fmt.Errorf("-fuzzy-first-char-weight must be at least 1")
+	}
+
+	if cfg.AllowEmptyInput {
+		cfg.Map.Popular = cfg.MaxLimit
+	}
+
+	cfg.Timeout = time.Duration(timeoutSec) * time.Second
+	cfg.CORSOrigins = suggest.SplitList(corsOrigins)
+
+	if cfg.Locales, cfg.DefaultLocale, err = parseLocales(locales, cfg.DefaultLocale); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+
+		name := envName(f.Name)
+		if value, ok := lookupEnv(name); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, name, e)
+			}
+		}
+	})
+
+	return err
+}
+
+func applyFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		if err := fs.Set(name, configValue(value)); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+func configValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		items = append(items, fmt.Sprint(item))
+	}
+
+	return strings.Join(items, ",")
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseLocales canonicalizes the -locales tags and the default one among
+// them.
+func parseLocales(s, def string) ([]string, string, error) {
+	locales := make([]string, 0)
+	for _, locale := range suggest.SplitList(s) {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid locale %q: %v", locale, err)
+		}
+		locales = append(locales, tag.String())
+	}
+
+	if len(locales) == 0 {
+		if def != "" {
+			return nil, "", fmt.Errorf("-default-locale requires -locales")
+		}
+		return nil, "", nil
+	}
+
+	if def == "" {
+		return locales, locales[0], nil
+	}
+
+	tag, err := language.Parse(def)
+	if err != nil || !slices.Contains(locales, tag.String()) {
+		return nil, "", fmt.Errorf("-default-locale must be one of %v", locales)
+	}
+
+	return locales, tag.String(), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"suggestion/suggest"
+)
+
+func noEnv(string) (string, bool) { return "", false }
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func TestPeriod(t *testing.T) {
+	cfg, err := loadConfig([]string{"-period", "30s"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Period != 30*time.Second {
+		t.Errorf("period %v, want 30s", cfg.Period)
+	}
+
+	// bare minutes of earlier versions are rejected rather than misread
+	if _, err := loadConfig([]string{"-period", "15"}, noEnv); err == nil {
+		t.Error("-period 15 accepted")
+	}
+
+	if _, err := loadConfig(nil, env(map[string]string{"SUGGEST_PERIOD": "15"})); err == nil {
+		t.Error("SUGGEST_PERIOD=15 accepted")
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	vars := env(map[string]string{
+		"SUGGEST_FILE":    "env.json",
+		"SUGGEST_PORT":    "9090",
+		"SUGGEST_TIMEOUT": "5",
+	})
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     func(string) (string, bool)
+		file    string
+		port    int
+		timeout time.Duration
+		period  time.Duration
+	}{
+		{"defaults", nil, noEnv, "suggestions.json", 8080, 2 * time.Second, 15 * time.Minute},
+		{"env over defaults", nil, vars, "env.json", 9090, 5 * time.Second, 15 * time.Minute},
+		{"flags over env", []string{"-port", "7070", "-period", "1m"}, vars, "env.json", 7070, 5 * time.Second, time.Minute},
+	}
+
+	for _, tt := range tests {
+		cfg, err := loadConfig(tt.args, tt.env)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if cfg.File != tt.file || cfg.Port != tt.port || cfg.Timeout != tt.timeout || cfg.Period != tt.period {
+			t.Errorf("%s: file %q port %d timeout %v period %v, want %q %d %v %v",
+				tt.name, cfg.File, cfg.Port, cfg.Timeout, cfg.Period, tt.file, tt.port, tt.timeout, tt.period)
+		}
+	}
+
+	if _, err := loadConfig(nil, env(map[string]string{"SUGGEST_PORT": "http"})); err == nil {
+		t.Error("SUGGEST_PORT=http accepted")
+	}
+}
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+file: data.json
+port: 9000
+period: 5m
+max-limit: 50
+match-mode: substring
+cors-origins:
+  - https://a.example
+  - https://b.example
+`,
+		"config.json": `{
+	"file": "data.json",
+	"port": 9000,
+	"period": "5m",
+	"max-limit": 50,
+	"match-mode": "substring",
+	"cors-origins": ["https://a.example", "https://b.example"]
+}`,
+	}
+
+	for name, data := range files {
+		path := writeConfig(t, name, data)
+
+		cfg, err := loadConfig([]string{"-config", path}, noEnv)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.File != "data.json" || cfg.Port != 9000 || cfg.Period != 5*time.Minute || cfg.MaxLimit != 50 ||
+			cfg.Map.Mode != suggest.MatchSubstring || !slices.Equal(cfg.CORSOrigins, []string{"https://a.example", "https://b.example"}) {
+			t.Errorf("%s: resolved to %+v", name, cfg)
+		}
+
+		// flags override the file
+		cfg, err = loadConfig([]string{"-config", path, "-port", "9001"}, noEnv)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Port != 9001 || cfg.MaxLimit != 50 {
+			t.Errorf("%s with -port 9001: port %d max-limit %d, want 9001 50", name, cfg.Port, cfg.MaxLimit)
+		}
+	}
+}
+
+func TestConfigFileUnknownKey(t *testing.T) {
+	for name, data := range map[string]string{
+		"config.yaml": "port: 9000\nmax_limit: 50\n",
+		"config.json": `{"port": 9000, "max_limit": 50}`,
+	} {
+		_, err := loadConfig([]string{"-config", writeConfig(t, name, data)}, noEnv)
+		if err == nil || !strings.Contains(err.Error(), `unknown setting "max_limit"`) {
+			t.Errorf("%s: error %v, want an unknown setting error", name, err)
+		}
+	}
+}
+
+func TestConfigExample(t *testing.T) {
+	if _, err := loadConfig([]string{"-config", "config.example.yaml"}, noEnv); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisUnsupported(t *testing.T) {
+	redis := []string{"-redis-addr", "localhost:6379"}
+	if _, err := loadConfig(redis, noEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]string{
+		{"-phonetic"},
+		{"-cache-size", "100"},
+		{"-warm-prefixes", "prefixes.txt"},
+		{"-allow-empty-input"},
+	}
+
+	for _, args := range tests {
+		args = append(slices.Clone(redis), args...)
+		if _, err := loadConfig(args, noEnv); err == nil {
+			t.Errorf("%q accepted", args)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventStreamType = "text/event-stream"
+	// comments sent on idle streams so that proxies don't close them
+	eventKeepAlive = 30 * time.Second
+	// events a slow subscriber may lag behind before missing some
+	eventBuffer = 16
+)
+
+// ReloadEvent is sent to the /admin/events subscribers after every
+// successful reload.
+type ReloadEvent struct {
+	Keys int       `json:"keys"`
+	Time time.Time `json:"time"`
+}
+
+// eventHub fans reload events out to the connected subscribers. Publishing
+// never blocks a reload: a subscriber whose buffer is full misses the event.
+type eventHub struct {
+	mx     sync.Mutex
+	subs   map[chan ReloadEvent]struct{}
+	closed bool
+}
+
+var events = &eventHub{subs: make(map[chan ReloadEvent]struct{})}
+
+// subscribe returns a channel of the events to come, closed on shutdown.
+func (h *eventHub) subscribe() chan ReloadEvent {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	ch := make(chan ReloadEvent, eventBuffer)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan ReloadEvent) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	delete(h.subs, ch)
+}
+
+func (h *eventHub) publish(event ReloadEvent) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// close ends every stream, so that they don't hold up a graceful shutdown.
+func (h *eventHub) close() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+	h.closed = true
+}
+
+// reloaded tells the subscribers about a successful reload.
+func reloaded() {
+	events.publish(ReloadEvent{Keys: suggestions.Len(), Time: time.Now().UTC()})
+}
+
+// Events streams a reload event as Server-Sent Events each time the data is
+// reloaded, until the client disconnects.
+func Events(w http.ResponseWriter, r *http.Request) {
+	// the stream outlives -write-timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	setContentType(w, eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: reload\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flush(w)
+	}
+}
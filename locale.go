@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"suggestion/suggest"
+)
+
+// localeStore keeps a store per locale, each loaded from its own locale
+// tagged data files, and answers a query from the store of the locale its
+// request was resolved to by withLocale.
+type localeStore struct {
+	// the default locale is the first one
+	locales []string
+	stores  map[string]suggest.Store
+	matcher language.Matcher
+}
+
+type localeKey struct{}
+
+// newLocaleStore creates the stores of locales, def being the fallback for
+// requests accepting none of them.
+func newLocaleStore(locales []string, def string, newStore func(locale string) suggest.Store) *localeStore {
+	s := &localeStore{locales: []string{def}, stores: make(map[string]suggest.Store, len(locales))}
+	for _, locale := range locales {
+		if locale != def {
+			s.locales = append(s.locales, locale)
+		}
+	}
+
+	tags := make([]language.Tag, 0, len(s.locales))
+	for _, locale := range s.locales {
+		s.stores[locale] = newStore(locale)
+		tags = append(tags, language.Make(locale))
+	}
+	s.matcher = language.NewMatcher(tags)
+
+	return s
+}
+
+// resolve returns the locale best matching an Accept-Language header.
+func (s *localeStore) resolve(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return s.locales[0]
+	}
+
+	_, i, confidence := s.matcher.Match(tags...)
+	if confidence == language.No {
+		return s.locales[0]
+	}
+
+	return s.locales[i]
+}
+
+// store returns the store of the locale the request of ctx was resolved to.
+func (s *localeStore) store(ctx context.Context) suggest.Store {
+	if locale := requestLocale(ctx); locale != "" {
+		return s.stores[locale]
+	}
+
+	return s.stores[s.locales[0]]
+}
+
+// Load loads every locale from path with the locale inserted before the
+// extension of each file, e.g. suggestions.en.json for suggestions.json.
+func (s *localeStore) Load(path string) error {
+	var errs []error
+	for _, locale := range s.locales {
+		if err := s.stores[locale].Load(localePath(path, locale)); err != nil {
+			errs = append(errs, fmt.Errorf("locale %s: %v", locale, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *localeStore) ListByKey(ctx context.Context, key string, opts suggest.ListOptions) []suggest.Suggestion {
+	return s.store(ctx).ListByKey(ctx, key, opts)
+}
+
+func (s *localeStore) Items(ctx context.Context, key string) []suggest.Item {
+	return s.store(ctx).Items(ctx, key)
+}
+
+func (s *localeStore) Keys(ctx context.Context, prefix string, offset, limit int) ([]string, int) {
+	return s.store(ctx).Keys(ctx, prefix, offset, limit)
+}
+
+func (s *localeStore) DidYouMean(ctx context.Context, key string, n int) []string {
+	if store, ok := s.store(ctx).(corrector); ok {
+		return store.DidYouMean(ctx, key, n)
+	}
+
+	return nil
+}
+
+func (s *localeStore) Len() int {
+	n := 0
+	for _, store := range s.stores {
+		n += store.Len()
+	}
+
+	return n
+}
+
+// Loaded reports whether every locale has data.
+func (s *localeStore) Loaded() bool {
+	for _, store := range s.stores {
+		if !store.Loaded() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Stats sums up the stats of the locales, the last load being the latest.
+func (s *localeStore) Stats() suggest.LoadStats {
+	var stats suggest.LoadStats
+	var errs []string
+	for _, locale := range s.locales {
+		st := s.stores[locale].Stats()
+		stats.Keys += st.Keys
+		stats.Items += st.Items
+		stats.Conflicts += st.Conflicts
+		if st.LastLoad != nil && (stats.LastLoad == nil || st.LastLoad.After(*stats.LastLoad)) {
+			stats.LastLoad = st.LastLoad
+		}
+
+		if st.Failed {
+			stats.Failed = true
+			errs = append(errs, fmt.Sprintf("locale %s: %s", locale, st.LastError))
+		}
+	}
+	stats.LastError = strings.Join(errs, "; ")
+
+	return stats
+}
+
+// files returns the data files of every locale for watching.
+func (s *localeStore) files(path string) []string {
+	files := make([]string, 0, len(s.locales))
+	for _, locale := range s.locales {
+		files = append(files, suggest.SplitList(localePath(path, locale))...)
+	}
+
+	return files
+}
+
+// localePath inserts locale before the extension of every file of a
+// comma-separated list, compression suffixes aside.
+func localePath(path, locale string) string {
+	files := suggest.SplitList(path)
+	for i, file := range files {
+		gz := ""
+		if strings.HasSuffix(file, ".gz") {
+			file, gz = strings.TrimSuffix(file, ".gz"), ".gz"
+		}
+
+		ext := filepath.Ext(file)
+		files[i] = strings.TrimSuffix(file, ext) + "." + locale + ext + gz
+	}
+
+	return strings.Join(files, ",")
+}
+
+// withLocale resolves the request's locale from Accept-Language and reports
+// it in Content-Language along with the available ones.
+func withLocale(f http.HandlerFunc, store *localeStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locale := store.resolve(r.Header.Get("Accept-Language"))
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", locale)
+		w.Header().Set("X-Available-Locales", strings.Join(store.locales, ", "))
+
+		f.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, locale)))
+	}
+}
+
+// requestLocale returns the locale withLocale resolved, "" without locales.
+func requestLocale(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
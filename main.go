@@ -1,49 +1,465 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"suggestion/suggest"
+)
+
+// set at build time with -ldflags "-X main.gitCommit=... -X main.buildTime=..."
+var (
+	gitCommit = "unknown"
+	buildTime = "unknown"
 )
 
 var (
-	suggestions = NewSuggestionsMap()
+	suggestions suggest.Store
+	// nil without -locales, otherwise also the suggestions store
+	locales   *localeStore
+	startedAt = time.Now()
+
+	defaultLimit  int
+	maxLimit      int
+	maxBody       int64
+	minInput      int
+	highlightPre  string
+	highlightPost string
+	maxBatch      int
+	emptyAs404    bool
+	allowEmpty    bool
+	slowThreshold time.Duration
+	allowJSONP    bool
+	// measured as the /v2 JSON response, 0 means no limit
+	maxResponseBytes int
+	// corrections are offered below this many matches, 0 disables them
+	didYouMeanBelow int
+	didYouMeanMax   int
+	// set with the Redis store, which only indexes keys by prefix
+	prefixOnly bool
+	// highlights ignore diacritics as the keys do
+	foldDiacritics bool
 )
 
 func main() {
-	fname := flag.String("file", "suggestions.json", "file with suggestions data")
-	periodSec := flag.Int("period", 15, "updating period")
-	port := flag.Int("port", 8080, "listening port")
-	timeoutSec := flag.Int("timeout", 2, "request timeout")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "benchmark" {
+		if err := benchmark(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	configure(cfg)
+
+	if err := loadInitial(cfg); err != nil {
+		if !cfg.AllowEmpty {
+			log.Fatalf("can't load suggestions from %s: %v; pass -allow-empty to start without data", cfg.File, err)
+		}
+		log.Printf("starting without data: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloadPeriod.Store(int64(cfg.Period))
+	reloadBreaker := breaker{threshold: cfg.BreakerThreshold, maxBackoff: cfg.BreakerMaxBackoff}
+
+	if cfg.Watch {
+		extra := suggest.SplitList(cfg.Map.Synonyms)
+		if locales != nil {
+			extra = append(extra, locales.files(cfg.File)...)
+		}
+
+		go func() {
+			if err := watch(ctx, cfg.File, extra, cfg.Debounce); err != nil {
+				log.Printf("watch %s: %v, falling back to polling", cfg.File, err)
+				reload(ctx, cfg.File, reloadBreaker)
+			}
+		}()
+	} else {
+		go reload(ctx, cfg.File, reloadBreaker)
+	}
+
+	router, err := newRouter(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.WriteTimeout > 0 && cfg.WriteTimeout <= cfg.Timeout {
+		log.Printf("write timeout %v does not exceed request timeout %v, timed out requests will be cut off without an error response", cfg.WriteTimeout, cfg.Timeout)
+	}
 
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           withInFlight(withRequestID(withAccessLog(router.ServeHTTP, cfg.LogFormat, cfg.TrustForwarded))),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	server.RegisterOnShutdown(events.close)
+
+	fmt.Printf("Server listening on 0.0.0.0:%d\n", cfg.Port)
+	serve(server, cfg.TLSCert, cfg.TLSKey, cfg.Grace)
+}
+
+// configure sets up the store and the settings the handlers run with.
+func configure(cfg Config) {
+	defaultLimit, maxLimit, minInput, maxBody = cfg.Limit, cfg.MaxLimit, cfg.MinInput, cfg.MaxBody
+	highlightPre, highlightPost = cfg.HighlightPre, cfg.HighlightPost
+	foldDiacritics = cfg.Map.FoldDiacritics
+	maxBatch, emptyAs404 = cfg.MaxBatch, cfg.EmptyAs404
+	allowEmpty, slowThreshold, logFormat = cfg.AllowEmptyInput, cfg.SlowThreshold, cfg.LogFormat
+	allowJSONP, maxResponseBytes = cfg.AllowJSONP, cfg.MaxResponseBytes
+	didYouMeanBelow, didYouMeanMax = cfg.DidYouMeanBelow, cfg.DidYouMeanMax
+	prefixOnly = cfg.RedisAddr != ""
+	msgpackType, ndjsonType = cfg.MsgpackType, cfg.NDJSONType
+
+	if len(cfg.Locales) > 0 {
+		locales = newLocaleStore(cfg.Locales, cfg.DefaultLocale, func(locale string) suggest.Store {
+			return newStore(cfg, cfg.RedisPrefix+":"+locale)
+		})
+		suggestions = locales
+	} else {
+		locales, suggestions = nil, newStore(cfg, cfg.RedisPrefix)
+	}
+}
+
+func newStore(cfg Config, redisPrefix string) suggest.Store {
+	if cfg.RedisAddr != "" {
+		return suggest.NewRedisStore(cfg.RedisAddr, redisPrefix, cfg.Map)
+	}
+
+	return suggest.New(cfg.Map)
+}
+
+// newRouter routes the API, the probes, the metrics and, as configured, the
+// admin and profiling endpoints through their middleware. ctx bounds the
+// background work of the middleware.
+func newRouter(ctx context.Context, cfg Config) (*Router, error) {
+	registry := prometheus.NewRegistry()
+	if err := metrics.Register(registry); err != nil {
+		return nil, err
+	}
+
+	suggestMethods := []string{http.MethodGet, http.MethodPost}
+	batchMethods := []string{http.MethodPost}
+	if len(cfg.CORSOrigins) > 0 {
+		suggestMethods = append(suggestMethods, http.MethodOptions)
+		batchMethods = append(batchMethods, http.MethodOptions)
+	}
+
+	var limiter *RateLimiter
+	if cfg.Rate > 0 {
+		limiter = NewRateLimiter(cfg.Rate, cfg.Burst)
+		go limiter.Run(ctx, time.Minute)
+	}
+
+	suggestChain := func(f http.HandlerFunc) http.HandlerFunc {
+		f = withTimeout(withRecover(f), cfg.MinTimeout, cfg.Timeout)
+		if cfg.Gzip {
+			f = withGzip(f, cfg.GzipMinSize)
+		}
+
+		if locales != nil {
+			f = withLocale(f, locales)
+		}
+
+		return withCORS(withMetrics(withRateLimit(f, limiter, cfg.TrustForwarded)), cfg.CORSOrigins)
+	}
+
+	router := &Router{http.NewServeMux()}
+	router.Route("/v1/api/suggest", suggestChain(Suggest), suggestMethods...)
+	router.Route("/v2/api/suggest", suggestChain(SuggestV2), suggestMethods...)
+	router.Route("/v1/api/suggest/batch", suggestChain(SuggestBatch), batchMethods...)
+	router.Get("/healthz", Health)
+	router.Get("/readyz", Ready)
+	router.Get("/version", Version)
+	router.Get("/openapi.json", OpenAPI)
+	router.Get("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP)
+	if cfg.ReloadToken != "" {
+		router.Post("/admin/reload", withToken(Reload(cfg.File), cfg.ReloadToken))
+		router.Get("/admin/stats", withToken(Stats, cfg.ReloadToken))
+		router.Post("/admin/reload-interval", withToken(ReloadInterval, cfg.ReloadToken))
+		router.Get("/admin/key/{id}", withToken(Key, cfg.ReloadToken))
+		router.Get("/admin/keys", withToken(Keys, cfg.ReloadToken))
+		router.Get("/admin/snapshot", withToken(Snapshot, cfg.ReloadToken))
+		router.Post("/admin/validate", withToken(Validate(cfg.Map), cfg.ReloadToken))
+		router.Get("/admin/events", withToken(Events, cfg.ReloadToken))
+	}
+	if cfg.Pprof {
+		router.Pprof()
+	}
+
+	return router, nil
+}
+
+func serve(server *http.Server, certFile, keyFile string, grace time.Duration) {
+	errs := make(chan error, 1)
 	go func() {
+		if certFile != "" {
+			errs <- server.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+
+		errs <- server.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case s := <-sig:
+		log.Printf("shutting down: received %v", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 		for {
-			suggestions.Load(*fname)
-			<-time.After(time.Duration(*periodSec) * time.Minute)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				log.Printf("draining: %d requests in flight", inFlight.Load())
+			}
 		}
 	}()
 
-	router := Router{http.NewServeMux()}
-	router.Post("/v1/api/suggest", withTimeout(Suggest, time.Duration(*timeoutSec)*time.Second))
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+}
+
+// reloadPeriod is the polling period, changed at runtime through
+// /admin/reload-interval which then signals reloadPeriodChanged.
+var (
+	reloadPeriod        atomic.Int64
+	reloadPeriodChanged = make(chan struct{}, 1)
+)
+
+func reload(ctx context.Context, fname string, b breaker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloadPeriodChanged:
+			continue
+		case <-time.After(b.delay(time.Duration(reloadPeriod.Load()))):
+		}
+
+		b.record(load(fname))
+	}
+}
+
+func setReloadPeriod(period time.Duration) time.Duration {
+	previous := time.Duration(reloadPeriod.Swap(int64(period)))
+	select {
+	case reloadPeriodChanged <- struct{}{}:
+	default:
+	}
+
+	return previous
+}
+
+// loadInitial loads the data before serving, from -snapshot-load if set and
+// usable, otherwise from the data files.
+func loadInitial(cfg Config) error {
+	if cfg.SnapshotLoad == "" {
+		return suggestions.Load(cfg.File)
+	}
+
+	store, ok := suggestions.(snapshotter)
+	if !ok {
+		log.Printf("ignoring -snapshot-load: the store doesn't support snapshots")
+		return suggestions.Load(cfg.File)
+	}
+
+	if err := store.LoadSnapshot(cfg.SnapshotLoad); err != nil {
+		log.Printf("falling back to %s", cfg.File)
+		return suggestions.Load(cfg.File)
+	}
 
-	fmt.Printf("Server listening on 0.0.0.0:%d\n", *port)
-	http.ListenAndServe(fmt.Sprintf(":%d", *port), router)
+	log.Printf("loaded %d keys from snapshot %s", suggestions.Len(), cfg.SnapshotLoad)
+	return nil
+}
+
+// load reloads fname, failures are logged by the store.
+func load(fname string) error {
+	if err := suggestions.Load(fname); err != nil {
+		return err
+	}
+	reloaded()
+
+	return nil
 }
 
 // handler
 
 func Suggest(w http.ResponseWriter, r *http.Request) {
+	obj, resp, ok := listSuggestions(w, r)
+	if !ok {
+		return
+	}
+
+	accept := r.Header.Get("Accept")
+	ndjson := accepts(accept, defaultNDJSONType) || accepts(accept, ndjsonType)
+	switch {
+	case obj.texts && ndjson:
+		writeNDJSON(w, http.StatusOK, resp.texts())
+	case obj.texts:
+		writeEncoded(w, r, http.StatusOK, resp.texts())
+	case ndjson:
+		writeNDJSON(w, http.StatusOK, resp.Suggestions)
+	default:
+		writeEncoded(w, r, http.StatusOK, resp.Suggestions)
+	}
+}
+
+func SuggestV2(w http.ResponseWriter, r *http.Request) {
+	obj, resp, ok := listSuggestions(w, r)
+	if !ok {
+		return
+	}
+
+	// the texts alone would lose the total
+	if obj.texts {
+		writeError(w, http.StatusBadRequest, ValidationError{{Field: "format", Message: "format texts is only supported by /v1/api/suggest"}})
+		return
+	}
+
+	writeEncoded(w, r, http.StatusOK, resp)
+}
+
+// Health is the liveness probe: it succeeds as long as the process serves.
+func Health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, health())
+}
+
+// Ready is the readiness probe: it fails until the first successful load.
+func Ready(w http.ResponseWriter, r *http.Request) {
+	resp := health()
+
+	status := http.StatusOK
+	if !resp.Loaded {
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, resp)
+}
+
+func health() HealthResponse {
+	return HealthResponse{
+		Status:   "ok",
+		Uptime:   time.Since(startedAt).Round(time.Second).String(),
+		Loaded:   suggestions.Loaded(),
+		InFlight: inFlight.Load(),
+		Version:  buildVersion(),
+	}
+}
+
+func Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildVersion())
+}
+
+func listSuggestions(w http.ResponseWriter, r *http.Request) (*SuggestionRequest, SuggestionsResponse, bool) {
 	obj := new(SuggestionRequest)
 
-	if err := bind(r.Body, obj); err != nil {
+	fromQuery := r.Method == http.MethodGet
+	if !fromQuery {
+		err := bind(http.MaxBytesReader(w, r.Body, maxBody), obj)
+		switch {
+		case errors.Is(err, errEmptyBody):
+			// some proxies strip POST bodies, so take the query string instead
+			fromQuery = true
+		case err != nil:
+			writeBindError(w, err)
+			return nil, SuggestionsResponse{}, false
+		case obj.Input == nil:
+			obj.Input = queryInput(r.URL.Query())
+		}
+	}
+
+	// malformed parameters are reported along with invalid values
+	var errs ValidationError
+	if fromQuery {
+		errs = obj.bindQuery(r.URL.Query())
+	}
+
+	if err := append(errs, obj.validate()...).err(); err != nil {
 		writeError(w, http.StatusBadRequest, err)
+		return nil, SuggestionsResponse{}, false
+	}
+
+	if r.Context().Err() != nil {
+		return nil, SuggestionsResponse{}, false
+	}
+
+	resp := lookup(r.Context(), obj)
+	if emptyAs404 && resp.Total == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no suggestions for %s", obj.Input))
+		return nil, SuggestionsResponse{}, false
+	}
+
+	if maxResponseBytes > 0 {
+		truncated, err := resp.truncate(maxResponseBytes)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return nil, SuggestionsResponse{}, false
+		}
+
+		if truncated {
+			w.Header().Set(truncatedHeader, "true")
+		}
+	}
+
+	return obj, resp, true
+}
+
+func SuggestBatch(w http.ResponseWriter, r *http.Request) {
+	obj := new(BatchRequest)
+
+	if err := bind(http.MaxBytesReader(w, r.Body, maxBody), obj); err != nil {
+		writeBindError(w, err)
 		return
 	}
 
@@ -52,13 +468,90 @@ func Suggest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := json.Marshal(suggestions.ListByKey(*obj.Input))
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
+	resp := BatchResponse{Results: make(map[string][]suggest.Suggestion, len(obj.Inputs))}
+	for _, input := range obj.Inputs {
+		req := obj.SuggestionRequest
+		req.Input = Input{input}
+		if err := req.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("input %q: %w", input, err))
+			return
+		}
+
+		if r.Context().Err() != nil {
+			return
+		}
+
+		resp.Results[input] = lookup(r.Context(), &req).Suggestions
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func lookup(ctx context.Context, obj *SuggestionRequest) SuggestionsResponse {
+	start := time.Now()
+	list := suggestions.ListByKey(ctx, obj.Input[0], suggest.ListOptions{
+		Mode:     obj.mode,
+		Category: obj.Category,
+		MinCost:  obj.MinCost,
+		MaxCost:  obj.MaxCost,
+		Seed:     obj.Seed,
+		Also:     obj.Input[1:],
+	})
+	if elapsed := time.Since(start); slowThreshold > 0 && elapsed > slowThreshold {
+		logSlowQuery(ctx, obj.Input, elapsed, len(list))
+	}
+	total := len(list)
+	if obj.Offset != nil {
+		list = list[min(*obj.Offset, len(list)):]
+	}
+	if limit := obj.limit(); len(list) > limit {
+		list = list[:limit]
+	}
+	metrics.results.Observe(float64(len(list)))
+
+	for i := range list {
+		if !obj.IncludeCost {
+			list[i].Cost = nil
+		}
+
+		if !obj.IncludeCategory {
+			list[i].Category = nil
+		}
+
+		if !obj.IncludeScore {
+			list[i].Score = nil
+		}
+
+		if !obj.IncludePayload {
+			list[i].Payload = nil
+		}
+
+		if !obj.Debug {
+			list[i].MatchedKey, list[i].MatchType = nil, nil
+		}
+
+		if obj.Highlight {
+			highlighted := highlight(list[i].Text, obj.Input.spans(list[i].Text), highlightPre, highlightPost)
+			list[i].Highlighted = &highlighted
+		}
+	}
+
+	resp := SuggestionsResponse{Suggestions: list, Total: total, Locale: requestLocale(ctx)}
+	if locales != nil {
+		resp.Locales = locales.locales
+	}
+
+	if store, ok := suggestions.(corrector); ok && total < didYouMeanBelow {
+		resp.DidYouMean = store.DidYouMean(ctx, obj.Input[0], didYouMeanMax)
 	}
 
-	writeSuccess(w, http.StatusOK, body)
+	return resp
+}
+
+// corrector is implemented by stores that can correct misspelled queries,
+// the Redis store keeps no fuzzy index.
+type corrector interface {
+	DidYouMean(ctx context.Context, key string, n int) []string
 }
 
 // router
@@ -68,130 +561,477 @@ type Router struct {
 }
 
 func (r *Router) Post(url string, handler http.HandlerFunc) {
-	post := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusNotImplemented)
-			return
+	r.Route(url, handler, http.MethodPost)
+}
+
+func (r *Router) Get(url string, handler http.HandlerFunc) {
+	r.Route(url, handler, http.MethodGet)
+}
+
+func (r *Router) Pprof() {
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+func (r *Router) Route(url string, handler http.HandlerFunc, methods ...string) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				handler.ServeHTTP(w, r)
+				return
+			}
 		}
 
-		handler.ServeHTTP(w, r)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
 	})
 
-	r.Handle(url, post)
+	r.Handle(url, h)
 }
 
-// storage
+// models
 
-type SuggestionsMap struct {
-	mx   sync.Mutex
-	data map[string][]mapItem
+type SuggestionRequest struct {
+	Input    Input   `json:"input"`
+	Limit    *int    `json:"limit"`
+	Offset   *int    `json:"offset"`
+	Category *string `json:"category"`
+	MinCost  *int    `json:"min_cost"`
+	MaxCost  *int    `json:"max_cost"`
+	Seed     *int64  `json:"seed"`
+	Mode     *string `json:"match_mode"`
+	Format   *string `json:"format"`
+
+	mode  suggest.MatchMode
+	texts bool
+
+	IncludeCost     bool `json:"include_cost"`
+	IncludeCategory bool `json:"include_category"`
+	IncludeScore    bool `json:"include_score"`
+	IncludePayload  bool `json:"include_payload"`
+	Highlight       bool `json:"highlight"`
+	Debug           bool `json:"debug"`
 }
 
-type mapItem struct {
-	Cost int
-	Name string
+const (
+	formatFull  = "full"
+	formatTexts = "texts"
+)
+
+// Validate checks every field and returns all the problems found as a
+// ValidationError.
+func (s *SuggestionRequest) Validate() error {
+	return s.validate().err()
 }
 
-func NewSuggestionsMap() SuggestionsMap {
-	return SuggestionsMap{
-		data: make(map[string][]mapItem),
+func (s *SuggestionRequest) validate() ValidationError {
+	var errs ValidationError
+	if len(s.Input) == 0 {
+		errs.add("input", fmt.Errorf("input is empty"))
+	}
+
+	for i, input := range s.Input {
+		field := "input"
+		if len(s.Input) > 1 {
+			field = fmt.Sprintf("input[%d]", i)
+		}
+
+		input = strings.TrimSpace(input)
+		s.Input[i] = input
+		// only a single empty input asks for the top suggestions
+		if input == "" && (!allowEmpty || len(s.Input) > 1) {
+			errs.add(field, fmt.Errorf("input is empty"))
+		}
+
+		if input != "" && utf8.RuneCountInString(input) < minInput {
+			errs.add(field, fmt.Errorf("input must be at least %d characters long", minInput))
+		}
+	}
+
+	if s.Offset != nil && *s.Offset < 0 {
+		errs.add("offset", fmt.Errorf("offset must not be negative"))
 	}
+
+	if s.MinCost != nil && s.MaxCost != nil && *s.MinCost > *s.MaxCost {
+		errs.add("min_cost", fmt.Errorf("min_cost must not exceed max_cost"))
+	}
+
+	if s.Mode != nil {
+		mode, err := suggest.ParseMatchMode(*s.Mode)
+		if err == nil && mode == suggest.MatchSubstring && prefixOnly {
+			err = fmt.Errorf("match_mode substring isn't supported by the Redis store")
+		}
+		errs.add("match_mode", err)
+		s.mode = mode
+	}
+
+	if s.Format != nil {
+		switch *s.Format {
+		case formatFull:
+		case formatTexts:
+			s.texts = true
+		default:
+			errs.add("format", fmt.Errorf("unknown format %q, expected %s or %s", *s.Format, formatFull, formatTexts))
+		}
+	}
+
+	return errs
 }
 
-func (s *SuggestionsMap) Load(path string) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Println(err)
-		return
+func (s *SuggestionRequest) bindQuery(q url.Values) ValidationError {
+	s.Input = queryInput(q)
+	s.Category = queryString(q, "category")
+	s.Mode = queryString(q, "match_mode")
+	s.Format = queryString(q, "format")
+
+	var errs ValidationError
+	var err error
+	s.Limit, err = queryInt(q, "limit")
+	errs.add("limit", err)
+
+	s.Offset, err = queryInt(q, "offset")
+	errs.add("offset", err)
+
+	s.MinCost, err = queryInt(q, "min_cost")
+	errs.add("min_cost", err)
+
+	s.MaxCost, err = queryInt(q, "max_cost")
+	errs.add("max_cost", err)
+
+	seed, err := queryInt(q, "seed")
+	errs.add("seed", err)
+	if seed != nil {
+		s.Seed = new(int64)
+		*s.Seed = int64(*seed)
 	}
 
-	suggestions := make([]suggestionDTO, 0)
-	if err = json.Unmarshal(data, &suggestions); err != nil {
-		log.Println(err)
-		return
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{
+		{"include_cost", &s.IncludeCost},
+		{"include_category", &s.IncludeCategory},
+		{"include_score", &s.IncludeScore},
+		{"include_payload", &s.IncludePayload},
+		{"highlight", &s.Highlight},
+		{"debug", &s.Debug},
+	} {
+		*flag.value, err = queryBool(q, flag.name)
+		errs.add(flag.name, err)
 	}
 
-	s.init(suggestions)
+	return errs
 }
 
-func (s *SuggestionsMap) ListByKey(key string) []Suggestion {
-	s.mx.Lock()
-	items, ok := s.data[key]
-	s.mx.Unlock()
-	if !ok {
-		return []Suggestion{}
+func (s *SuggestionRequest) limit() int {
+	limit := defaultLimit
+	if s.Limit != nil && *s.Limit > 0 {
+		limit = *s.Limit
 	}
 
-	suggestions := make([]Suggestion, 0, len(items))
-	for i := range items {
-		suggestions = append(suggestions, Suggestion{
-			Position: i,
-			Text:     items[i].Name,
-		})
+	if limit > maxLimit {
+		return maxLimit
 	}
 
-	return suggestions
+	return limit
 }
 
-func (s *SuggestionsMap) init(dtos []suggestionDTO) {
-	data := make(map[string][]mapItem)
-	for _, dto := range dtos {
-		item := mapItem{
-			Cost: dto.Cost,
-			Name: dto.Name,
-		}
+// Input is either a single string or an array of strings OR'd together,
+// which all match as if queried separately and are ranked as one list.
+type Input []string
 
-		if _, ok := data[dto.ID]; !ok {
-			data[dto.ID] = make([]mapItem, 1)
-			data[dto.ID][0] = item
-			continue
-		}
+func (in *Input) UnmarshalJSON(data []byte) error {
+	// null means missing, like for the other fields
+	if string(data) == "null" {
+		return nil
+	}
 
-		data[dto.ID] = append(data[dto.ID], item)
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*in = Input{single}
+		return nil
+	}
 
-		for i := len(data[dto.ID]) - 1; i > 0; i-- {
-			if data[dto.ID][i].Cost < data[dto.ID][i-1].Cost {
-				data[dto.ID][i], data[dto.ID][i-1] = data[dto.ID][i-1], data[dto.ID][i]
-			}
-		}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
 	}
 
-	s.mx.Lock()
-	s.data = data
-	s.mx.Unlock()
+	if list == nil {
+		list = Input{}
+	}
+	*in = list
+	return nil
 }
 
-// models
+func (in Input) MarshalJSON() ([]byte, error) {
+	if len(in) == 1 {
+		return json.Marshal(in[0])
+	}
 
-type SuggestionRequest struct {
-	Input *string `json:"input"`
+	return json.Marshal([]string(in))
 }
 
-func (s *SuggestionRequest) Validate() error {
-	if s.Input == nil {
-		return fmt.Errorf("input is empty")
+func (in Input) String() string {
+	if len(in) == 1 {
+		return strconv.Quote(in[0])
+	}
+
+	return fmt.Sprintf("%q", []string(in))
+}
+
+// spans returns the occurrences in text of the first of the inputs occurring
+// in it, for highlighting.
+func (in Input) spans(text string) [][2]int {
+	for _, input := range in {
+		if spans := suggest.Find(text, input, foldDiacritics); len(spans) > 0 {
+			return spans
+		}
 	}
 
 	return nil
 }
 
+type BatchRequest struct {
+	Inputs []string `json:"inputs"`
+
+	SuggestionRequest
+}
+
+func (s *BatchRequest) Validate() error {
+	var errs ValidationError
+	if len(s.Inputs) == 0 {
+		errs.add("inputs", fmt.Errorf("inputs are empty"))
+	}
+
+	if len(s.Inputs) > maxBatch {
+		errs.add("inputs", fmt.Errorf("too many inputs, at most %d allowed", maxBatch))
+	}
+
+	return errs.err()
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request, writeError returns
+// them along with the joined messages.
+type ValidationError []FieldError
+
+func (e *ValidationError) add(field string, err error) {
+	if err != nil {
+		*e = append(*e, FieldError{Field: field, Message: err.Error()})
+	}
+}
+
+// err returns e as an error, nil if there are no field errors.
+func (e ValidationError) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fe.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+type BatchResponse struct {
+	Results map[string][]suggest.Suggestion `json:"results"`
+}
+
 type SuggestionsResponse struct {
-	Suggestions []Suggestion `json:"suggestions"`
+	Suggestions []suggest.Suggestion `json:"suggestions"`
+	Total       int                  `json:"total"`
+	Locale      string               `json:"locale,omitempty"`
+	Locales     []string             `json:"locales,omitempty"`
+	Truncated   bool                 `json:"truncated,omitempty"`
+	DidYouMean  []string             `json:"did_you_mean,omitempty"`
+}
+
+// truncate drops the lowest ranked suggestions until the response encodes
+// to at most max bytes of JSON, and reports whether it had to.
+func (r *SuggestionsResponse) truncate(max int) (bool, error) {
+	full, err := json.Marshal(r)
+	if err != nil || len(full) <= max {
+		return false, err
+	}
+
+	list := r.Suggestions
+	r.Suggestions, r.Truncated = []suggest.Suggestion{}, true
+	empty, err := json.Marshal(r)
+	if err != nil {
+		return false, err
+	}
+
+	// the list encodes to its items joined by commas, the first one takes
+	// none, which the - 1 accounts for
+	size := len(empty) - 1
+	for _, s := range list {
+		item, err := json.Marshal(s)
+		if err != nil {
+			return false, err
+		}
+
+		if size += len(item) + 1; size > max {
+			break
+		}
+		r.Suggestions = append(r.Suggestions, s)
+	}
+
+	return true, nil
+}
+
+func (r SuggestionsResponse) texts() []string {
+	texts := make([]string, 0, len(r.Suggestions))
+	for _, s := range r.Suggestions {
+		texts = append(texts, s.Text)
+	}
+
+	return texts
+}
+
+// Error codes stay the same across releases, unlike the messages, for
+// clients to tell errors apart.
+const (
+	codeInvalidInput     = "INVALID_INPUT"
+	codeUnauthorized     = "UNAUTHORIZED"
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeTooLarge         = "TOO_LARGE"
+	codeRateLimited      = "RATE_LIMITED"
+	codeTimeout          = "TIMEOUT"
+	codeInternal         = "INTERNAL"
+	codeNotImplemented   = "NOT_IMPLEMENTED"
+	codeUnavailable      = "UNAVAILABLE"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeInvalidInput,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusNotImplemented:        codeNotImplemented,
+	http.StatusServiceUnavailable:    codeUnavailable,
+}
+
+// errTimeout is answered when a request runs out of time.
+var errTimeout = errors.New("timeout")
+
+type ErrorResponse struct {
+	Code      string       `json:"code"`
+	Error     string       `json:"error"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+type HealthResponse struct {
+	Status   string          `json:"status"`
+	Uptime   string          `json:"uptime"`
+	Loaded   bool            `json:"loaded"`
+	InFlight int64           `json:"in_flight"`
+	Version  VersionResponse `json:"version"`
+}
+
+type VersionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+type ReloadResponse struct {
+	Keys     int    `json:"keys"`
+	Duration string `json:"duration"`
+}
+
+type KeyResponse struct {
+	Key   string         `json:"key"`
+	Items []suggest.Item `json:"items"`
+}
+
+type KeysResponse struct {
+	Keys  []string `json:"keys"`
+	Total int      `json:"total"`
 }
 
-type Suggestion struct {
-	Text     string `json:"text"`
-	Position int    `json:"position"`
+type ReloadIntervalRequest struct {
+	Interval string `json:"interval"`
 }
 
-type suggestionDTO struct {
-	ID   string `json:"id"`
-	Cost int    `json:"cost"`
-	Name string `json:"name"`
+type ReloadIntervalResponse struct {
+	Interval string `json:"interval"`
+	Previous string `json:"previous"`
 }
 
 // utils
 
+func buildVersion() VersionResponse {
+	return VersionResponse{
+		Commit:    gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// queryInput reads every input query parameter, repeating it ORs inputs.
+func queryInput(q url.Values) Input {
+	if !q.Has("input") {
+		return nil
+	}
+
+	return Input(q["input"])
+}
+
+func queryString(q url.Values, name string) *string {
+	if !q.Has(name) {
+		return nil
+	}
+
+	value := q.Get(name)
+	return &value
+}
+
+func queryInt(q url.Values, name string) (*int, error) {
+	if !q.Has(name) {
+		return nil, nil
+	}
+
+	value, err := strconv.Atoi(q.Get(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return &value, nil
+}
+
+func queryBool(q url.Values, name string) (bool, error) {
+	if !q.Has(name) {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(q.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return value, nil
+}
+
+var errEmptyBody = errors.New("request body is empty")
+
 func bind(body io.ReadCloser, obj interface{}) error {
 	defer body.Close()
 
@@ -200,42 +1040,349 @@ func bind(body io.ReadCloser, obj interface{}) error {
 		return err
 	}
 
+	if len(bytes.TrimSpace(data)) == 0 {
+		return errEmptyBody
+	}
+
 	return json.Unmarshal(data, obj)
 }
 
+func writeBindError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, err)
+}
+
 func writeError(w http.ResponseWriter, status int, err error) {
-	w.Header().Set("Content-Type", "application/json")
+	resp := ErrorResponse{Code: errorCode(status, err), Error: err.Error(), RequestID: w.Header().Get(requestIDHeader)}
+	var invalid ValidationError
+	if errors.As(err, &invalid) {
+		resp.Errors = invalid
+	}
+
+	// withRequestID has already put the ID in the response headers
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setContentType(w, jsonType)
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		log.Println(err)
+	}
+}
+
+// errorCode returns the code of an error answered with status.
+func errorCode(status int, err error) string {
+	if errors.Is(err, errTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return codeTimeout
+	}
+
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+
+	return codeInternal
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeSuccess(w, status, body)
+}
+
+// writeEncoded writes obj as MessagePack if the client accepts it and as
+// JSON otherwise. MessagePack reuses the json tags, so both encodings carry
+// the same fields.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, obj interface{}) {
+	if callback := r.URL.Query().Get("callback"); allowJSONP && r.Method == http.MethodGet && callback != "" {
+		writeJSONP(w, status, callback, obj)
+		return
+	}
+
+	if accept := r.Header.Get("Accept"); !accepts(accept, defaultMsgpackType) && !accepts(accept, msgpackType) {
+		writeJSON(w, status, obj)
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(obj); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setContentType(w, msgpackType)
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
+
+func init() {
+	// json.RawMessage is a []byte, which msgpack would encode as bin
+	msgpack.Register(json.RawMessage(nil), encodeRawJSON, nil)
+}
+
+// encodeRawJSON encodes a raw JSON value, such as a suggestion payload, as
+// the MessagePack value it holds, keeping integers apart from floats.
+func encodeRawJSON(enc *msgpack.Encoder, v reflect.Value) error {
+	raw := v.Bytes()
+	if len(raw) == 0 {
+		return enc.EncodeNil()
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+
+	return enc.Encode(jsonNumbers(value))
+}
+
+// jsonNumbers replaces the json.Numbers in a decoded JSON value with int64s
+// or, when they don't fit one, float64s.
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	}
+
+	return value
+}
+
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// writeJSONP wraps obj in a call to callback for legacy cross-domain embeds.
+// The callback must be a plain, possibly dotted, identifier so that it can't
+// inject script, and the leading comment defuses content sniffing attacks.
+func writeJSONP(w http.ResponseWriter, status int, callback string, obj interface{}) {
+	if len(callback) > 128 || !jsonpCallback.MatchString(callback) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid callback %q", callback))
+		return
+	}
+
+	body, err := json.Marshal(obj)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setContentType(w, javascriptType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(status)
-	if _, err = w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err))); err != nil {
+	if _, err := fmt.Fprintf(w, "/**/%s(%s);", callback, body); err != nil {
 		log.Println(err)
 	}
 }
 
+func writeNDJSON[T any](w http.ResponseWriter, status int, list []T) {
+	setContentType(w, ndjsonType)
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	for _, item := range list {
+		if err := enc.Encode(item); err != nil {
+			log.Println(err)
+			return
+		}
+		flush(w)
+	}
+}
+
+const (
+	jsonType           = "application/json"
+	javascriptType     = "application/javascript"
+	binaryType         = "application/octet-stream"
+	defaultMsgpackType = "application/msgpack"
+	defaultNDJSONType  = "application/x-ndjson"
+)
+
+// the MessagePack and NDJSON types can be overridden for clients expecting
+// e.g. application/x-msgpack, the default ones are accepted anyway
+var (
+	msgpackType = defaultMsgpackType
+	ndjsonType  = defaultNDJSONType
+)
+
+// setContentType is the single place setting response media types, textual
+// ones are declared UTF-8.
+func setContentType(w http.ResponseWriter, mediaType string) {
+	if mediaType != msgpackType && mediaType != binaryType {
+		mediaType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+}
+
 func writeSuccess(w http.ResponseWriter, status int, body []byte) {
 	if body == nil {
 		w.WriteHeader(status)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	setContentType(w, jsonType)
 	w.WriteHeader(status)
 	if _, err := w.Write(body); err != nil {
 		log.Println(err)
 	}
 }
 
-func withTimeout(f http.HandlerFunc, timeout time.Duration) http.HandlerFunc {
+// highlight wraps the spans of text, as returned by suggest.Find, with pre
+// and post, keeping the original text in between.
+func highlight(text string, spans [][2]int, pre, post string) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		b.WriteString(text[last:span[0]])
+		b.WriteString(pre)
+		b.WriteString(text[span[0]:span[1]])
+		b.WriteString(post)
+		last = span[1]
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+const timeoutHeader = "X-Timeout-Ms"
+
+// truncatedHeader flags responses cut to -max-response-bytes.
+const truncatedHeader = "X-Truncated"
+
+// withTimeout bounds f by ceiling, or by the shorter timeout a client asks
+// for in the X-Timeout-Ms header or the timeout_ms query parameter, raised to
+// floor.
+// The body can't carry it since the deadline starts before it is read.
+func withTimeout(f http.HandlerFunc, floor, ceiling time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		timeout, err := requestTimeout(r, floor, ceiling)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: w.Header().Clone()}
 		done := make(chan struct{})
 		go func() {
-			f.ServeHTTP(w, r)
-			done <- struct{}{}
+			f.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
 		}()
 
 		select {
-		case <-time.After(timeout):
-			writeError(w, http.StatusInternalServerError, fmt.Errorf("timeout"))
+		case <-ctx.Done():
+			if tw.claim(false) {
+				writeError(w, http.StatusInternalServerError, errTimeout)
+				return
+			}
+			<-done
 		case <-done:
 		}
 	}
 }
+
+func requestTimeout(r *http.Request, floor, ceiling time.Duration) (time.Duration, error) {
+	value := r.Header.Get(timeoutHeader)
+	if value == "" {
+		value = r.URL.Query().Get("timeout_ms")
+	}
+
+	if value == "" {
+		return ceiling, nil
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected a non-negative number of milliseconds", value)
+	}
+
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout < floor {
+		timeout = floor
+	}
+	if timeout > ceiling {
+		timeout = ceiling
+	}
+
+	return timeout, nil
+}
+
+// timeoutWriter lets either the handler or the timeout branch of withTimeout
+// own the underlying ResponseWriter, whichever writes first.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	once        sync.Once
+	handlerWon  bool
+	wroteHeader bool
+}
+
+func (tw *timeoutWriter) claim(byHandler bool) bool {
+	tw.once.Do(func() {
+		tw.handlerWon = byHandler
+		if byHandler {
+			for k, v := range tw.header {
+				tw.w.Header()[k] = v
+			}
+		}
+	})
+
+	return tw.handlerWon == byHandler
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if !tw.claim(true) || tw.wroteHeader {
+		return
+	}
+
+	tw.wroteHeader = true
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.claim(true) {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	if tw.claim(true) {
+		flush(tw.w)
+	}
+}
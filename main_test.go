@@ -0,0 +1,691 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"suggestion/suggest"
+)
+
+func texts(list []suggest.Suggestion) []string {
+	texts := make([]string, 0, len(list))
+	for _, suggestion := range list {
+		texts = append(texts, suggestion.Text)
+	}
+
+	return texts
+}
+
+const testData = `[
+	{"id": "hel", "name": "hello world", "cost": 70},
+	{"id": "hel", "name": "hello", "cost": 10},
+	{"id": "he", "name": "he", "cost": 10},
+	{"id": "hel", "name": "helm", "cost": 200},
+	{"id": "he", "name": "hey", "cost": 20},
+	{"id": "se", "name": "sea", "cost": 10}
+]`
+
+// setup configures the handlers with the flags in args and loads data, which
+// is written to a temporary file, into a fresh store.
+func setup(t *testing.T, data string, args ...string) Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "suggestions.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(append([]string{"-file", path}, args...), noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configure(cfg)
+	if err := loadInitial(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+// do sends a request with body, if not empty, to handler and returns the
+// recorded response.
+func do(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+// decode unmarshals the body of a response with the wanted status into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	items := make([]string, 0, 150)
+	for i := 0; i < 150; i++ {
+		items = append(items, fmt.Sprintf(`{"id": "item", "name": "item %03d", "cost": %d}`, i, i))
+	}
+	setup(t, "["+strings.Join(items, ",")+"]")
+
+	tests := []struct {
+		limit string
+		want  int
+	}{
+		{"null", 10},
+		{"0", 10},
+		{"-5", 10},
+		{"1", 1},
+		{"42", 42},
+		{"100", 100},
+		{"1000000", 100},
+	}
+
+	for _, tt := range tests {
+		var list []suggest.Suggestion
+		decode(t, do(Suggest, http.MethodPost, "/v1/api/suggest", `{"input": "item", "limit": `+tt.limit+`}`), http.StatusOK, &list)
+
+		if len(list) != tt.want {
+			t.Errorf("limit %s: %d suggestions, want %d", tt.limit, len(list), tt.want)
+		}
+		// truncated after ranking, so the cheapest items are kept
+		if len(list) > 0 && list[0].Text != "item 000" {
+			t.Errorf("limit %s: first suggestion %q, want item 000", tt.limit, list[0].Text)
+		}
+	}
+}
+
+func TestLimitAboveMatches(t *testing.T) {
+	setup(t, testData)
+
+	var list []suggest.Suggestion
+	decode(t, do(Suggest, http.MethodPost, "/v1/api/suggest", `{"input": "hel", "limit": 50}`), http.StatusOK, &list)
+
+	if got, want := texts(list), []string{"hello", "hello world", "helm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("texts %q, want %q", got, want)
+	}
+}
+
+func TestIncludeCost(t *testing.T) {
+	setup(t, testData)
+
+	tests := []struct {
+		body string
+		cost bool
+	}{
+		{`{"input": "hel"}`, false},
+		{`{"input": "hel", "include_cost": false}`, false},
+		{`{"input": "hel", "include_cost": true}`, true},
+	}
+
+	for _, tt := range tests {
+		var list []map[string]json.RawMessage
+		decode(t, do(Suggest, http.MethodPost, "/v1/api/suggest", tt.body), http.StatusOK, &list)
+
+		if len(list) != 3 {
+			t.Fatalf("%s: %d suggestions, want 3", tt.body, len(list))
+		}
+		for _, suggestion := range list {
+			if _, ok := suggestion["cost"]; ok != tt.cost {
+				t.Errorf("%s: cost present %v, want %v", tt.body, ok, tt.cost)
+			}
+		}
+		if tt.cost && string(list[0]["cost"]) != "10" {
+			t.Errorf("%s: first cost %s, want 10", tt.body, list[0]["cost"])
+		}
+	}
+}
+
+func TestWriteErrorEscapes(t *testing.T) {
+	msg := `bad "input" in C:\data\file` + "\n"
+
+	w := httptest.NewRecorder()
+	writeError(w, http.StatusBadRequest, errors.New(msg))
+
+	var resp ErrorResponse
+	decode(t, w, http.StatusBadRequest, &resp)
+	if resp.Error != msg {
+		t.Errorf("error %q, want %q", resp.Error, msg)
+	}
+	if resp.Code != codeInvalidInput {
+		t.Errorf("code %q, want %q", resp.Code, codeInvalidInput)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text, query string
+		fold        bool
+		want        string
+	}{
+		{"Hello World", "hel", false, "<b>Hel</b>lo World"},
+		{"banana", "an", false, "b<b>an</b><b>an</b>a"},
+		{"Tom and TOMATO", "tom", false, "<b>Tom</b> and <b>TOM</b>ATO"},
+		{"Ёлка ёлочка", "ёл", false, "<b>Ёл</b>ка <b>ёл</b>очка"},
+		{"hello", "xyz", false, "hello"},
+		{"hello", "", false, "hello"},
+		{"he", "hello", false, "he"},
+		{"Smart  TV\tbox", "smart tv box", false, "<b>Smart  TV\tbox</b>"},
+		{"Smart TV", "  smart   tv ", false, "<b>Smart TV</b>"},
+		{"Café crème", "cafe", false, "Café crème"},
+		{"Café crème", "cafe cre", true, "<b>Café crè</b>me"},
+		{"Cafe\u0301 au lait", "café", true, "<b>Cafe\u0301</b> au lait"},
+		{"Ёлка", "ел", true, "<b>Ёл</b>ка"},
+	}
+
+	for _, tt := range tests {
+		foldDiacritics = tt.fold
+		if got := highlight(tt.text, Input{tt.query}.spans(tt.text), "<b>", "</b>"); got != tt.want {
+			t.Errorf("highlight(%q, %q) with fold %v = %q, want %q", tt.text, tt.query, tt.fold, got, tt.want)
+		}
+	}
+	foldDiacritics = false
+}
+
+func TestHighlightNormalized(t *testing.T) {
+	setup(t, `[{"id": "Crème  brûlée", "name": "Crème  brûlée"}]`, "-fold-diacritics")
+
+	w := do(Suggest, http.MethodGet, "/v1/api/suggest?input=creme+bru&highlight=true", "")
+	var list []suggest.Suggestion
+	decode(t, w, http.StatusOK, &list)
+	if len(list) != 1 || list[0].Highlighted == nil {
+		t.Fatalf("suggestions %+v, want one highlighted", list)
+	}
+	if got, want := *list[0].Highlighted, "<b>Crème  brû</b>lée"; got != want {
+		t.Errorf("highlighted %q, want %q", got, want)
+	}
+}
+
+func TestRouteMethodNotAllowed(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router := Router{http.NewServeMux()}
+	router.Route("/v1/api/suggest", ok, http.MethodGet, http.MethodPost)
+	router.Post("/v1/api/suggest/batch", ok)
+
+	tests := []struct {
+		method, target string
+		status         int
+		allow          string
+	}{
+		{http.MethodGet, "/v1/api/suggest", http.StatusOK, ""},
+		{http.MethodPost, "/v1/api/suggest", http.StatusOK, ""},
+		{http.MethodPut, "/v1/api/suggest", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodDelete, "/v1/api/suggest", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodGet, "/v1/api/suggest/batch", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPut, "/v1/api/suggest/batch", http.StatusMethodNotAllowed, "POST"},
+	}
+
+	for _, tt := range tests {
+		w := do(router.ServeHTTP, tt.method, tt.target, "")
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.target, got, tt.allow)
+		}
+
+		if tt.status == http.StatusMethodNotAllowed {
+			var resp ErrorResponse
+			decode(t, w, tt.status, &resp)
+			if resp.Code != codeMethodNotAllowed {
+				t.Errorf("%s %s: code %q, want %q", tt.method, tt.target, resp.Code, codeMethodNotAllowed)
+			}
+		}
+	}
+}
+
+func TestPprof(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{nil, http.StatusNotFound},
+		{[]string{"-pprof"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		router, err := newRouter(t.Context(), setup(t, testData, tt.args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		if w.Code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.args, w.Code, tt.status)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suggestions.json")
+	cfg, err := loadConfig([]string{"-file", path}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configure(cfg)
+
+	var resp HealthResponse
+	decode(t, do(Ready, http.MethodGet, "/readyz", ""), http.StatusServiceUnavailable, &resp)
+	if resp.Loaded || resp.Status != "unavailable" {
+		t.Errorf("before loading: %+v", resp)
+	}
+	// liveness doesn't wait for the data
+	decode(t, do(Health, http.MethodGet, "/healthz", ""), http.StatusOK, &resp)
+
+	// a failed load leaves it unready
+	if err := load(path); err == nil {
+		t.Fatal("missing file loaded")
+	}
+	decode(t, do(Ready, http.MethodGet, "/readyz", ""), http.StatusServiceUnavailable, &resp)
+
+	if err := os.WriteFile(path, []byte(testData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := load(path); err != nil {
+		t.Fatal(err)
+	}
+	decode(t, do(Ready, http.MethodGet, "/readyz", ""), http.StatusOK, &resp)
+	if !resp.Loaded || resp.Status != "ok" {
+		t.Errorf("after loading: %+v", resp)
+	}
+}
+
+func TestListSuggestionsSources(t *testing.T) {
+	setup(t, testData)
+
+	tests := []struct {
+		name, target, body string
+		want               []string
+	}{
+		{"body only", "/v1/api/suggest", `{"input": "hel"}`, []string{"hello", "hello world", "helm"}},
+		{"query only", "/v1/api/suggest?input=se", "", []string{"sea"}},
+		// the query string is ignored, limit included
+		{"both", "/v1/api/suggest?input=se&limit=1", `{"input": "he"}`, []string{"he", "hello", "hey", "hello world", "helm"}},
+		// a body without input takes it from the query string
+		{"body without input", "/v1/api/suggest?input=se", `{"limit": 5}`, []string{"sea"}},
+	}
+
+	for _, tt := range tests {
+		var list []suggest.Suggestion
+		decode(t, do(Suggest, http.MethodPost, tt.target, tt.body), http.StatusOK, &list)
+		if got := texts(list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	setup(t, testData)
+
+	tests := []struct {
+		name, accept, target string
+		want                 string
+	}{
+		{"json", "", "/v1/api/suggest?input=he", "application/json; charset=utf-8"},
+		{"error", "", "/v1/api/suggest?input=he&limit=x", "application/json; charset=utf-8"},
+		{"ndjson", "application/x-ndjson", "/v1/api/suggest?input=he", "application/x-ndjson; charset=utf-8"},
+		{"msgpack", "application/msgpack", "/v1/api/suggest?input=he", "application/msgpack"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		Suggest(w, r)
+
+		if got := w.Header().Get("Content-Type"); got != tt.want {
+			t.Errorf("%s: Content-Type %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// overridden types are served for the defaults too
+	setup(t, testData, "-msgpack-content-type", "application/x-msgpack", "-ndjson-content-type", "application/jsonl")
+	for accept, want := range map[string]string{
+		"application/msgpack":  "application/x-msgpack",
+		"application/x-ndjson": "application/jsonl; charset=utf-8",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/api/suggest?input=he", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		Suggest(w, r)
+
+		if got := w.Header().Get("Content-Type"); got != want {
+			t.Errorf("overridden, Accept %s: Content-Type %q, want %q", accept, got, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	setContentType(w, "application/vnd.example+json")
+	if got, want := w.Header().Get("Content-Type"), "application/vnd.example+json; charset=utf-8"; got != want {
+		t.Errorf("Content-Type %q, want %q", got, want)
+	}
+}
+
+func TestMsgpackPayload(t *testing.T) {
+	setup(t, `[
+		{"id": "tv", "name": "tv stand", "cost": 10, "payload": {"sku": 42, "price": 9.99, "tags": ["oak", "wall"], "new": true, "parent": null}},
+		{"id": "tv", "name": "tv box", "cost": 20, "payload": "boxed"},
+		{"id": "tv", "name": "tv", "cost": 30}
+	]`)
+
+	// the payloads as JSON re-encodes them, so that both sides compare alike
+	payloads := func(list []map[string]interface{}) []string {
+		out := make([]string, 0, len(list))
+		for _, suggestion := range list {
+			payload, err := json.Marshal(suggestion["payload"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, string(payload))
+		}
+		return out
+	}
+
+	var fromJSON, fromMsgpack []map[string]interface{}
+	decode(t, do(Suggest, http.MethodGet, "/v1/api/suggest?input=tv&include_payload=true", ""), http.StatusOK, &fromJSON)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/api/suggest?input=tv&include_payload=true", nil)
+	r.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	Suggest(w, r)
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &fromMsgpack); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`{"new":true,"parent":null,"price":9.99,"sku":42,"tags":["oak","wall"]}`, `"boxed"`, "null"}
+	if got := payloads(fromJSON); !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON payloads %q, want %q", got, want)
+	}
+	if got := payloads(fromMsgpack); !reflect.DeepEqual(got, want) {
+		t.Errorf("MessagePack payloads %q, want %q", got, want)
+	}
+	if sku := fromMsgpack[0]["payload"].(map[string]interface{})["sku"]; reflect.TypeOf(sku).Kind() == reflect.Float64 {
+		t.Errorf("MessagePack sku %v is a float", sku)
+	}
+}
+
+func TestInputUnmarshal(t *testing.T) {
+	tests := []struct {
+		body string
+		want Input
+		err  bool
+	}{
+		{`{"input": "hel"}`, Input{"hel"}, false},
+		{`{"input": ["hel", "se"]}`, Input{"hel", "se"}, false},
+		{`{"input": []}`, Input{}, false},
+		{`{"input": null}`, nil, false},
+		{`{}`, nil, false},
+		{`{"input": 42}`, nil, true},
+		{`{"input": ["hel", 42]}`, nil, true},
+		{`{"input": {"text": "hel"}}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		var req SuggestionRequest
+		err := json.Unmarshal([]byte(tt.body), &req)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want error %v", tt.body, err, tt.err)
+			continue
+		}
+		if tt.err && !strings.Contains(err.Error(), "input must be a string or an array of strings") {
+			t.Errorf("%s: unclear error %v", tt.body, err)
+		}
+		if !tt.err && (strings.Join(req.Input, ",") != strings.Join(tt.want, ",") || (req.Input == nil) != (tt.want == nil)) {
+			t.Errorf("%s: input %#v, want %#v", tt.body, req.Input, tt.want)
+		}
+	}
+}
+
+func TestInputShapes(t *testing.T) {
+	setup(t, testData)
+
+	tests := []struct {
+		body   string
+		status int
+		want   []string
+	}{
+		{`{"input": "se"}`, http.StatusOK, []string{"sea"}},
+		// OR'd and ranked together
+		{`{"input": ["se", "hel"]}`, http.StatusOK, []string{"hello", "sea", "hello world", "helm"}},
+		{`{"input": []}`, http.StatusBadRequest, nil},
+		{`{}`, http.StatusBadRequest, nil},
+		{`{"input": ["se", ""]}`, http.StatusBadRequest, nil},
+		{`{"input": 42}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		w := do(Suggest, http.MethodPost, "/v1/api/suggest", tt.body)
+		if tt.status != http.StatusOK {
+			var resp ErrorResponse
+			decode(t, w, tt.status, &resp)
+			if resp.Code != codeInvalidInput {
+				t.Errorf("%s: code %q, want %q", tt.body, resp.Code, codeInvalidInput)
+			}
+			continue
+		}
+
+		var list []suggest.Suggestion
+		decode(t, w, tt.status, &list)
+		if got := texts(list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	setup(t, testData, "-min-input", "2")
+
+	tests := []struct {
+		name, method, target, body string
+		want                       []FieldError
+	}{
+		{"body", http.MethodPost, "/v1/api/suggest", `{"input": ["h", ""], "offset": -1, "min_cost": 5, "max_cost": 1, "match_mode": "regex", "format": "xml"}`, []FieldError{
+			{"input[0]", "input must be at least 2 characters long"},
+			{"input[1]", "input is empty"},
+			{"offset", "offset must not be negative"},
+			{"min_cost", "min_cost must not exceed max_cost"},
+			{"match_mode", `unknown match mode "regex"`},
+			{"format", `unknown format "xml", expected full or texts`},
+		}},
+		// malformed parameters are reported along with invalid values
+		{"query", http.MethodGet, "/v1/api/suggest?input=h&limit=ten&include_cost=maybe", "", []FieldError{
+			{"limit", `invalid limit: strconv.Atoi: parsing "ten": invalid syntax`},
+			{"include_cost", `invalid include_cost: strconv.ParseBool: parsing "maybe": invalid syntax`},
+			{"input", "input must be at least 2 characters long"},
+		}},
+	}
+
+	for _, tt := range tests {
+		var resp ErrorResponse
+		decode(t, do(Suggest, tt.method, tt.target, tt.body), http.StatusBadRequest, &resp)
+
+		if fmt.Sprint(resp.Errors) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: errors\n%v\nwant\n%v", tt.name, resp.Errors, tt.want)
+		}
+		if want := ValidationError(tt.want).Error(); resp.Error != want {
+			t.Errorf("%s: error %q, want %q", tt.name, resp.Error, want)
+		}
+		if resp.Code != codeInvalidInput {
+			t.Errorf("%s: code %q, want %q", tt.name, resp.Code, codeInvalidInput)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	setup(t, testData)
+
+	for _, target := range []string{"/v1/api/suggest?input=he", "/v1/api/suggest?input=he&format=full"} {
+		var list []map[string]interface{}
+		decode(t, do(Suggest, http.MethodGet, target, ""), http.StatusOK, &list)
+		if len(list) != 5 || list[0]["text"] != "he" || list[0]["position"] != 0.0 || list[1]["text"] != "hello" || list[1]["position"] != 1.0 {
+			t.Errorf("%s: %v", target, list)
+		}
+	}
+
+	var texts []string
+	decode(t, do(Suggest, http.MethodPost, "/v1/api/suggest", `{"input": "he", "format": "texts", "include_cost": true}`), http.StatusOK, &texts)
+	if want := []string{"he", "hello", "hey", "hello world", "helm"}; strings.Join(texts, ",") != strings.Join(want, ",") {
+		t.Errorf("texts: %q, want %q", texts, want)
+	}
+
+	// texts would lose the total of /v2
+	var resp ErrorResponse
+	decode(t, do(SuggestV2, http.MethodGet, "/v2/api/suggest?input=he&format=texts", ""), http.StatusBadRequest, &resp)
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "format" {
+		t.Errorf("/v2 texts: %+v", resp)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	list := make([]suggest.Suggestion, 0, 5)
+	for i, text := range []string{"a", "bb", `"quoted"`, "ёлка", "<b>"} {
+		list = append(list, suggest.Suggestion{Text: text, Position: i})
+	}
+
+	// the exact size of the response keeping n suggestions
+	size := func(n int) int {
+		resp := SuggestionsResponse{Suggestions: append([]suggest.Suggestion{}, list[:n]...), Total: len(list), Truncated: n < len(list)}
+		body, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(body)
+	}
+
+	for n := 0; n < len(list); n++ {
+		for _, max := range []int{size(n), size(n+1) - 1} {
+			resp := SuggestionsResponse{Suggestions: append([]suggest.Suggestion{}, list...), Total: len(list)}
+			truncated, err := resp.truncate(max)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(resp)
+			if !truncated || !resp.Truncated || len(resp.Suggestions) != n || len(body) > max {
+				t.Errorf("max %d: truncated %v, %d suggestions in %d bytes, want %d suggestions", max, truncated, len(resp.Suggestions), len(body), n)
+			}
+		}
+	}
+
+	// one byte short of the full response already truncates
+	full := size(len(list))
+	for max, want := range map[int]bool{full: false, full + 1: false, full - 1: true} {
+		resp := SuggestionsResponse{Suggestions: append([]suggest.Suggestion{}, list...), Total: len(list)}
+		if truncated, _ := resp.truncate(max); truncated != want {
+			t.Errorf("max %d of %d: truncated %v, want %v", max, full, truncated, want)
+		}
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	setup(t, testData, "-max-response-bytes", "150")
+
+	w := do(SuggestV2, http.MethodGet, "/v2/api/suggest?input=he", "")
+	var resp SuggestionsResponse
+	decode(t, w, http.StatusOK, &resp)
+
+	if !resp.Truncated || w.Header().Get("X-Truncated") != "true" || len(resp.Suggestions) == 0 || len(resp.Suggestions) >= resp.Total {
+		t.Errorf("truncated %v, header %q, %d of %d suggestions", resp.Truncated, w.Header().Get("X-Truncated"), len(resp.Suggestions), resp.Total)
+	}
+	if w.Body.Len() > 150 {
+		t.Errorf("%d bytes, want at most 150", w.Body.Len())
+	}
+}
+
+// storeOnly hides the optional interfaces of the store it wraps.
+type storeOnly struct {
+	suggest.Store
+}
+
+func TestErrorCodes(t *testing.T) {
+	cfg := setup(t, testData, "-max-body", "64", "-empty-as-404")
+	loaded := suggestions
+
+	limiter := NewRateLimiter(1, 1)
+	limited := withRateLimit(Health, limiter, false)
+	limited(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	slow := withTimeout(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, time.Millisecond, 10*time.Millisecond)
+
+	router := Router{http.NewServeMux()}
+	router.Post("/v1/api/suggest/batch", SuggestBatch)
+
+	tests := []struct {
+		name   string
+		store  suggest.Store
+		send   func() *httptest.ResponseRecorder
+		status int
+		code   string
+	}{
+		{"invalid input", loaded, func() *httptest.ResponseRecorder {
+			return do(Suggest, http.MethodGet, "/v1/api/suggest?limit=x&input=he", "")
+		}, http.StatusBadRequest, codeInvalidInput},
+		{"unauthorized", loaded, func() *httptest.ResponseRecorder {
+			return do(withToken(Stats, "secret"), http.MethodGet, "/admin/stats", "")
+		}, http.StatusUnauthorized, codeUnauthorized},
+		{"not found", loaded, func() *httptest.ResponseRecorder {
+			return do(Suggest, http.MethodGet, "/v1/api/suggest?input=xyz", "")
+		}, http.StatusNotFound, codeNotFound},
+		{"method not allowed", loaded, func() *httptest.ResponseRecorder {
+			return do(router.ServeHTTP, http.MethodGet, "/v1/api/suggest/batch", "")
+		}, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"too large", loaded, func() *httptest.ResponseRecorder {
+			return do(Suggest, http.MethodPost, "/v1/api/suggest", `{"input": "`+strings.Repeat("h", 100)+`"}`)
+		}, http.StatusRequestEntityTooLarge, codeTooLarge},
+		{"rate limited", loaded, func() *httptest.ResponseRecorder {
+			return do(limited, http.MethodGet, "/healthz", "")
+		}, http.StatusTooManyRequests, codeRateLimited},
+		{"internal", loaded, func() *httptest.ResponseRecorder {
+			return do(withRecover(func(http.ResponseWriter, *http.Request) { panic("boom") }), http.MethodGet, "/", "")
+		}, http.StatusInternalServerError, codeInternal},
+		{"not implemented", storeOnly{loaded}, func() *httptest.ResponseRecorder {
+			return do(Snapshot, http.MethodGet, "/admin/snapshot", "")
+		}, http.StatusNotImplemented, codeNotImplemented},
+		{"unavailable", suggest.New(cfg.Map), func() *httptest.ResponseRecorder {
+			return do(Snapshot, http.MethodGet, "/admin/snapshot", "")
+		}, http.StatusServiceUnavailable, codeUnavailable},
+		{"timeout", loaded, func() *httptest.ResponseRecorder {
+			return do(slow, http.MethodGet, "/v1/api/suggest?input=he", "")
+		}, http.StatusInternalServerError, codeTimeout},
+	}
+
+	for _, tt := range tests {
+		suggestions = tt.store
+
+		var resp ErrorResponse
+		decode(t, tt.send(), tt.status, &resp)
+		if resp.Code != tt.code {
+			t.Errorf("%s: code %q, want %q", tt.name, resp.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"suggestion/suggest"
+)
+
+var (
+	metrics = NewMetrics()
+)
+
+type Metrics struct {
+	requests *prometheus.CounterVec
+	latency  prometheus.Histogram
+	results  prometheus.Histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "suggest_requests_total",
+			Help: "Number of suggest requests by status code.",
+		}, []string{"code"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "suggest_request_duration_seconds",
+			Help:    "Suggest request latency.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		results: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "suggest_results",
+			Help:    "Number of suggestions returned per request.",
+			Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+		}),
+	}
+}
+
+func (m *Metrics) Register(reg prometheus.Registerer) error {
+	for _, c := range append([]prometheus.Collector{m.requests, m.latency, m.results}, suggest.Collectors()...) {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func withMetrics(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		f.ServeHTTP(sw, r)
+
+		metrics.latency.Observe(time.Since(start).Seconds())
+		metrics.requests.WithLabelValues(strconv.Itoa(sw.status)).Inc()
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LogFormat string
+
+const (
+	LogText LogFormat = "text"
+	LogJSON LogFormat = "json"
+)
+
+func parseLogFormat(s string) (LogFormat, error) {
+	switch format := LogFormat(s); format {
+	case LogText, LogJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", s)
+	}
+}
+
+type accessRecord struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Latency   float64 `json:"latency_ms"`
+	Size      int     `json:"size"`
+	ClientIP  string  `json:"client_ip"`
+	RequestID string  `json:"request_id"`
+}
+
+var accessLog = log.New(os.Stdout, "", 0)
+
+// logFormat is the access log format for the logs written outside of
+// withAccessLog.
+var logFormat = LogText
+
+func withAccessLog(f http.HandlerFunc, format LogFormat, trustForwarded bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		f.ServeHTTP(sw, r)
+
+		rec := accessRecord{
+			Time:      start.Format(time.RFC3339),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    sw.status,
+			Latency:   float64(time.Since(start).Microseconds()) / 1000,
+			Size:      sw.size,
+			ClientIP:  clientIP(r, trustForwarded),
+			RequestID: requestID(r.Context()),
+		}
+
+		writeLog(format, rec, fmt.Sprintf("%s %s %s %s %d %d %.3fms %s", rec.Time, rec.ClientIP, rec.Method, rec.Path, rec.Status, rec.Size, rec.Latency, rec.RequestID))
+	}
+}
+
+// slowRecord is logged for queries matching for longer than -slow-threshold.
+type slowRecord struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	Message   string  `json:"msg"`
+	Input     Input   `json:"input"`
+	Duration  float64 `json:"duration_ms"`
+	Results   int     `json:"results"`
+	RequestID string  `json:"request_id"`
+}
+
+// logSlowQuery writes a warning about a slow query to the access log, in the
+// format of the access log.
+func logSlowQuery(ctx context.Context, input Input, elapsed time.Duration, results int) {
+	rec := slowRecord{
+		Time:      time.Now().Format(time.RFC3339),
+		Level:     "warning",
+		Message:   "slow query",
+		Input:     input,
+		Duration:  float64(elapsed.Microseconds()) / 1000,
+		Results:   results,
+		RequestID: requestID(ctx),
+	}
+
+	writeLog(logFormat, rec, fmt.Sprintf("%s %s %s input=%s duration=%.3fms results=%d %s", rec.Time, rec.Level, rec.Message, rec.Input, rec.Duration, rec.Results, rec.RequestID))
+}
+
+// writeLog writes rec to the access log as a JSON line in the JSON format,
+// otherwise text.
+func writeLog(format LogFormat, rec interface{}, text string) {
+	if format != LogJSON {
+		accessLog.Println(text)
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	accessLog.Println(string(line))
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID takes the request ID from the X-Request-ID header, or makes
+// one up, and both stores it in the context and echoes it in the response.
+func withRequestID(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		f.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID rejects IDs that would be unsafe to log or echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Println(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// inFlight counts the requests being served, reported while draining.
+var inFlight atomic.Int64
+
+func withInFlight(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		f.ServeHTTP(w, r)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += n
+
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	flush(sw.ResponseWriter)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func withRecover(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
+				writeError(w, http.StatusInternalServerError, fmt.Errorf("internal error"))
+			}
+		}()
+
+		f.ServeHTTP(w, r)
+	}
+}
+
+func withCORS(f http.HandlerFunc, origins []string) http.HandlerFunc {
+	if len(origins) == 0 {
+		return f
+	}
+
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join([]string{requestIDHeader, truncatedHeader}, ", "))
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join([]string{http.MethodGet, http.MethodPost, http.MethodOptions}, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join([]string{"Content-Type", requestIDHeader, timeoutHeader}, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		f.ServeHTTP(w, r)
+	}
+}
+
+func withGzip(f http.HandlerFunc, minSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !accepts(r.Header.Get("Accept-Encoding"), "gzip") {
+			f.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w, minSize: minSize, status: http.StatusOK}
+		defer gw.Close()
+
+		f.ServeHTTP(gw, r)
+	}
+}
+
+// accepts reports whether value is listed in an Accept-style header,
+// ignoring parameters such as q-values.
+func accepts(header, value string) bool {
+	for _, item := range strings.Split(header, ",") {
+		if strings.TrimSpace(strings.Split(item, ";")[0]) == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// gzipWriter buffers the response until it reaches minSize and only then
+// switches to compressing it, so small bodies go out as is.
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	zw      *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	gw.status = status
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.zw != nil {
+		return gw.zw.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) < gw.minSize {
+		return len(b), nil
+	}
+
+	if err := gw.start(); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Flush starts compressing right away since a flushing handler is streaming
+// and the final size can't be known.
+func (gw *gzipWriter) Flush() {
+	if gw.zw == nil {
+		if err := gw.start(); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := gw.zw.Flush(); err != nil {
+		log.Println(err)
+		return
+	}
+	flush(gw.ResponseWriter)
+}
+
+func (gw *gzipWriter) start() error {
+	h := gw.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	gw.zw = gzip.NewWriter(gw.ResponseWriter)
+	_, err := gw.zw.Write(gw.buf)
+	gw.buf = nil
+
+	return err
+}
+
+func (gw *gzipWriter) Close() {
+	if gw.zw != nil {
+		if err := gw.zw.Close(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+	if len(gw.buf) > 0 {
+		if _, err := gw.ResponseWriter.Write(gw.buf); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWithRecover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", withRecover(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	}))
+	mux.HandleFunc("/ok", withRecover(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/panic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("panicking handler: status %d, want 500", resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatalf("server down after a panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("after a panic: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	accessLog.SetOutput(&buf)
+	defer accessLog.SetOutput(os.Stdout)
+
+	setup(t, testData, "-slow-threshold", "1ns", "-log-format", "json")
+	defer func() { logFormat = LogText }()
+	do(Suggest, http.MethodGet, "/v1/api/suggest?input=he&limit=1", "")
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("%v in %q", err, buf.String())
+	}
+	if rec["level"] != "warning" || rec["msg"] != "slow query" || rec["input"] != "he" || rec["results"] != 5.0 {
+		t.Errorf("logged %v, want a warning about input he with 5 results", rec)
+	}
+	if _, ok := rec["duration_ms"].(float64); !ok {
+		t.Errorf("logged %v, want a duration_ms", rec)
+	}
+
+	buf.Reset()
+	setup(t, testData, "-slow-threshold", "1ns")
+	do(Suggest, http.MethodGet, "/v1/api/suggest?input=he", "")
+	if line := buf.String(); !strings.Contains(line, ` warning slow query input="he" `) || !strings.Contains(line, " results=5 ") {
+		t.Errorf("logged %q, want a warning about input he with 5 results", line)
+	}
+}
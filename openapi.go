@@ -0,0 +1,14 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	setContentType(w, jsonType)
+	w.Write(openAPISpec)
+}
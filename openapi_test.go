@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	var spec struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	decode(t, do(OpenAPI, http.MethodGet, "/openapi.json", ""), http.StatusOK, &spec)
+
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi %q, want 3.x", spec.OpenAPI)
+	}
+
+	for _, path := range []string{"/v1/api/suggest", "/v2/api/suggest", "/v1/api/suggest/batch", "/healthz", "/readyz", "/version"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("path %s missing", path)
+		}
+	}
+
+	for _, schema := range []string{"SuggestionRequest", "SuggestionsResponse", "Suggestion", "ErrorResponse"} {
+		if _, ok := spec.Components.Schemas[schema]; !ok {
+			t.Errorf("schema %s missing", schema)
+		}
+	}
+
+	// every reference resolves
+	for _, ref := range strings.Split(string(openAPISpec), `"$ref": "#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("reference to undefined schema %s", name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const limiterShards = 16
+
+// RateLimiter is a per-key token bucket limiter. Buckets are spread over
+// shards to reduce lock contention and are dropped once they refill, as a
+// full bucket is indistinguishable from a fresh one.
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	shards [limiterShards]limiterShard
+}
+
+type limiterShard struct {
+	mx      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := &RateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+	}
+
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*bucket)
+	}
+
+	return l
+}
+
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	shard := l.shard(key)
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		shard.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *RateLimiter) Cleanup(now time.Time) {
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.mx.Lock()
+		for key, b := range shard.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(shard.buckets, key)
+			}
+		}
+		shard.mx.Unlock()
+	}
+}
+
+func (l *RateLimiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.Cleanup(now)
+		}
+	}
+}
+
+func (l *RateLimiter) shard(key string) *limiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return &l.shards[h.Sum32()%limiterShards]
+}
+
+func withRateLimit(f http.HandlerFunc, l *RateLimiter, trustForwarded bool) http.HandlerFunc {
+	if l == nil {
+		return f
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(clientIP(r, trustForwarded), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+			return
+		}
+
+		f.ServeHTTP(w, r)
+	}
+}
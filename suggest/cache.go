@@ -0,0 +1,110 @@
+package suggest
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+)
+
+// lruCache keeps the results of the most recent queries. Every purge starts
+// a new generation, and results computed against an older generation are
+// not stored, so a reload racing with a query can't leave stale entries.
+type lruCache struct {
+	mx    sync.Mutex
+	size  int
+	gen   uint64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	list []Suggestion
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache) generation() uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.gen
+}
+
+func (c *lruCache) get(key string) ([]Suggestion, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+
+	return copySuggestions(el.Value.(*cacheEntry).list), true
+}
+
+func (c *lruCache) add(gen uint64, key string, list []Suggestion) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).list = copySuggestions(list)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, list: copySuggestions(list)})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *lruCache) purge() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.gen++
+	c.order.Init()
+	c.items = make(map[string]*list.Element, c.size)
+}
+
+// cacheKey identifies the results of a normalized query.
+func cacheKey(key string, mode MatchMode, opts ListOptions) string {
+	cacheKey := string(mode) + "\x00" + key
+	if opts.Category != nil {
+		cacheKey += "\x00" + *opts.Category
+	}
+	if opts.MinCost != nil {
+		cacheKey += "\x02" + strconv.Itoa(*opts.MinCost)
+	}
+	if opts.MaxCost != nil {
+		cacheKey += "\x03" + strconv.Itoa(*opts.MaxCost)
+	}
+	for _, k := range opts.Also {
+		cacheKey += "\x01" + k
+	}
+
+	return cacheKey
+}
+
+// copySuggestions protects cached results from handlers, which strip and set
+// optional fields in place.
+func copySuggestions(list []Suggestion) []Suggestion {
+	out := make([]Suggestion, len(list))
+	copy(out, list)
+
+	return out
+}
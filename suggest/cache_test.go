@@ -0,0 +1,74 @@
+package suggest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	list := func(text string) []Suggestion { return []Suggestion{{Text: text}} }
+
+	c.add(c.generation(), "a", list("a"))
+	c.add(c.generation(), "b", list("b"))
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a missing")
+	}
+
+	// b is now the least recently used
+	c.add(c.generation(), "c", list("c"))
+	if _, ok := c.get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if got, ok := c.get("a"); !ok || got[0].Text != "a" {
+		t.Errorf("a: %v %v", got, ok)
+	}
+
+	// results computed before a purge are not stored after it
+	gen := c.generation()
+	c.purge()
+	c.add(gen, "d", list("d"))
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.get(key); ok {
+			t.Errorf("%s cached after a purge", key)
+		}
+	}
+}
+
+func TestCacheReload(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CacheSize = 10
+	s := load(t, opts, `[{"id": "tv", "name": "tv stand", "cost": 10}]`)
+
+	hits := testutil.ToFloat64(metrics.cacheHits)
+	for i := 0; i < 2; i++ {
+		if got, want := texts(s.ListByKey(context.Background(), "tv", ListOptions{})), []string{"tv stand"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("query %d: %q, want %q", i, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.cacheHits) - hits; got != 1 {
+		t.Errorf("%v cache hits, want 1", got)
+	}
+
+	if err := s.Load(writeData(t, "reloaded.json", `[{"id": "tv", "name": "tv box", "cost": 10}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(s.ListByKey(context.Background(), "tv", ListOptions{})), []string{"tv box"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a reload: %q, want %q", got, want)
+	}
+}
+
+func TestCachedResultsCopied(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CacheSize = 10
+	s := load(t, opts, `[{"id": "tv", "name": "tv stand", "cost": 10}]`)
+
+	// handlers strip optional fields in place
+	s.ListByKey(context.Background(), "tv", ListOptions{})[0].Cost = nil
+	if list := s.ListByKey(context.Background(), "tv", ListOptions{}); list[0].Cost == nil {
+		t.Error("cached result modified by the caller")
+	}
+}
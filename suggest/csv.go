@@ -0,0 +1,140 @@
+package suggest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func isCSV(path string) bool {
+	return filepath.Ext(strings.TrimSuffix(path, ".gz")) == ".csv"
+}
+
+func ParseDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character, got %q", s)
+	}
+
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+func (s *SuggestionsMap) decodeCSV(r io.Reader) (map[string][]Item, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = s.opts.CSVDelimiter
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+
+	names := make(map[string]int, len(header))
+	for i, name := range header {
+		names[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	// columns are keyed by canonical field name
+	columns := make(map[string]int, len(dtoFields))
+	for _, field := range dtoFields {
+		if i, ok := names[strings.ToLower(s.opts.Fields.name(field))]; ok {
+			columns[field] = i
+		}
+	}
+
+	for _, name := range []string{"id", "cost", "name"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header: missing %q column", s.opts.Fields.name(name))
+		}
+	}
+
+	data := make(map[string][]Item)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			s.skip(err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		dto, err := parseRecord(record, columns)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			s.skip(fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+
+		s.add(data, dto)
+	}
+
+	return data, nil
+}
+
+func (s *SuggestionsMap) skip(err error) {
+	if s.skipped != nil {
+		s.skipped(err)
+		return
+	}
+
+	log.Printf("skipping malformed row: %v", err)
+}
+
+func parseRecord(record []string, columns map[string]int) (suggestionDTO, error) {
+	field := func(name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+
+		return record[i], true
+	}
+
+	var dto suggestionDTO
+	var ok bool
+	if dto.ID, ok = field("id"); !ok {
+		return dto, fmt.Errorf("missing id")
+	}
+
+	if dto.Name, ok = field("name"); !ok {
+		return dto, fmt.Errorf("missing name")
+	}
+
+	cost, ok := field("cost")
+	if !ok {
+		return dto, fmt.Errorf("missing cost")
+	}
+
+	var err error
+	if dto.Cost, err = strconv.Atoi(strings.TrimSpace(cost)); err != nil {
+		return dto, fmt.Errorf("invalid cost: %v", err)
+	}
+
+	dto.Category, _ = field("category")
+
+	// payloads are JSON texts, empty cells mean none
+	if payload, _ := field("payload"); strings.TrimSpace(payload) != "" {
+		if !json.Valid([]byte(payload)) {
+			return dto, fmt.Errorf("invalid payload: not JSON")
+		}
+		dto.Payload = json.RawMessage(payload)
+	}
+
+	return dto, nil
+}
@@ -0,0 +1,77 @@
+package suggest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+var dtoFields = []string{"id", "cost", "name", "category", "payload"}
+
+// FieldMap renames data file fields: keys are the canonical suggestionDTO
+// field names and values the names used by the source. Fields missing from
+// the map keep their canonical names.
+type FieldMap map[string]string
+
+func LoadFieldMap(path string) (FieldMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m FieldMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for field := range m {
+		if !contains(dtoFields, field) {
+			return nil, fmt.Errorf("%s: unknown field %q, expected one of %v", path, field, dtoFields)
+		}
+	}
+
+	return m, nil
+}
+
+func (m FieldMap) name(field string) string {
+	if name, ok := m[field]; ok {
+		return name
+	}
+
+	return field
+}
+
+// decode fills dto from a JSON object using the mapped names, ignoring any
+// other fields.
+func (m FieldMap) decode(raw map[string]json.RawMessage, dto *suggestionDTO) error {
+	targets := map[string]interface{}{
+		"id":       &dto.ID,
+		"cost":     &dto.Cost,
+		"name":     &dto.Name,
+		"category": &dto.Category,
+		"payload":  &dto.Payload,
+	}
+
+	for field, target := range targets {
+		value, ok := raw[m.name(field)]
+		if !ok {
+			continue
+		}
+
+		if err := json.Unmarshal(value, target); err != nil {
+			return fmt.Errorf("%s: %v", m.name(field), err)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
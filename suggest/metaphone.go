@@ -0,0 +1,211 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// minPhoneticPrefix is the shortest query code matched as a prefix of key
+// codes, shorter ones would match most of the data.
+const minPhoneticPrefix = 3
+
+// phoneticIndex maps the Metaphone codes of keys to their items.
+type phoneticIndex struct {
+	// sorted for prefix lookups
+	codes []string
+	items map[string][]Item
+}
+
+func newPhoneticIndex(keys []string, data map[string][]Item) *phoneticIndex {
+	p := &phoneticIndex{items: make(map[string][]Item)}
+	for _, key := range keys {
+		code := metaphone(key)
+		if code == "" {
+			continue
+		}
+
+		if _, ok := p.items[code]; !ok {
+			p.codes = append(p.codes, code)
+		}
+		p.items[code] = append(p.items[code], data[key]...)
+	}
+	sort.Strings(p.codes)
+
+	return p
+}
+
+// search returns the items of keys sounding like code, or with prefix, of
+// keys whose code starts with it.
+func (p *phoneticIndex) search(code string, prefix bool) []Item {
+	if p == nil || code == "" {
+		return nil
+	}
+
+	if !prefix || len(code) < minPhoneticPrefix {
+		return p.items[code]
+	}
+
+	items := make([]Item, 0)
+	for i := sort.SearchStrings(p.codes, code); i < len(p.codes) && strings.HasPrefix(p.codes[i], code); i++ {
+		items = append(items, p.items[p.codes[i]]...)
+	}
+
+	return items
+}
+
+// metaphone returns the Metaphone codes of the words of s separated by
+// spaces, or "" unless s is written in the Latin script. Accents are ignored
+// and words without letters skipped.
+func metaphone(s string) string {
+	codes := make([]string, 0)
+	for _, word := range strings.Fields(foldDiacritics(s)) {
+		letters := make([]byte, 0, len(word))
+		for _, r := range word {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+
+			r = unicode.ToUpper(r)
+			if r < 'A' || r > 'Z' {
+				return ""
+			}
+			letters = append(letters, byte(r))
+		}
+
+		if code := metaphoneWord(letters); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	return strings.Join(codes, " ")
+}
+
+// metaphoneWord implements Lawrence Philips' original Metaphone on upper
+// case ASCII letters, "0" standing for "th".
+func metaphoneWord(w []byte) string {
+	if len(w) == 0 {
+		return ""
+	}
+
+	// initial letter exceptions
+	switch {
+	case hasPrefix(w, "AE"), hasPrefix(w, "GN"), hasPrefix(w, "KN"), hasPrefix(w, "PN"), hasPrefix(w, "WR"):
+		w = w[1:]
+	case w[0] == 'X':
+		w = append([]byte{'S'}, w[1:]...)
+	case hasPrefix(w, "WH"):
+		w = append([]byte{'W'}, w[2:]...)
+	}
+
+	at := func(i int) byte {
+		if i < 0 || i >= len(w) {
+			return 0
+		}
+		return w[i]
+	}
+
+	var code strings.Builder
+	for i, c := range w {
+		prev, next := at(i-1), at(i+1)
+		if c == prev && c != 'C' {
+			continue
+		}
+
+		switch c {
+		case 'A', 'E', 'I', 'O', 'U':
+			if i == 0 {
+				code.WriteByte(c)
+			}
+		case 'B':
+			if !(prev == 'M' && i == len(w)-1) {
+				code.WriteByte('B')
+			}
+		case 'C':
+			switch {
+			case next == 'I' && at(i+2) == 'A', next == 'H' && prev != 'S':
+				code.WriteByte('X')
+			case next == 'I' || next == 'E' || next == 'Y':
+				if prev != 'S' {
+					code.WriteByte('S')
+				}
+			default:
+				code.WriteByte('K')
+			}
+		case 'D':
+			if next == 'G' && isFrontVowel(at(i+2)) {
+				code.WriteByte('J')
+			} else {
+				code.WriteByte('T')
+			}
+		case 'G':
+			switch {
+			case next == 'H' && i+2 < len(w) && !isVowel(at(i+2)):
+			case next == 'N' && (i+2 == len(w) || string(w[i+1:]) == "NED"):
+			case isFrontVowel(next) && prev != 'G':
+				code.WriteByte('J')
+			default:
+				code.WriteByte('K')
+			}
+		case 'H':
+			if isVowel(next) && !strings.ContainsRune("CSPTG", rune(prev)) {
+				code.WriteByte('H')
+			}
+		case 'K':
+			if prev != 'C' {
+				code.WriteByte('K')
+			}
+		case 'P':
+			if next == 'H' {
+				code.WriteByte('F')
+			} else {
+				code.WriteByte('P')
+			}
+		case 'Q':
+			code.WriteByte('K')
+		case 'S':
+			switch {
+			case next == 'H', next == 'I' && (at(i+2) == 'O' || at(i+2) == 'A'):
+				code.WriteByte('X')
+			default:
+				code.WriteByte('S')
+			}
+		case 'T':
+			switch {
+			case next == 'I' && (at(i+2) == 'O' || at(i+2) == 'A'):
+				code.WriteByte('X')
+			case next == 'H':
+				code.WriteByte('0')
+			case next == 'C' && at(i+2) == 'H':
+			default:
+				code.WriteByte('T')
+			}
+		case 'V':
+			code.WriteByte('F')
+		case 'W', 'Y':
+			if isVowel(next) {
+				code.WriteByte(c)
+			}
+		case 'X':
+			code.WriteString("KS")
+		case 'Z':
+			code.WriteByte('S')
+		default:
+			code.WriteByte(c)
+		}
+	}
+
+	return code.String()
+}
+
+func hasPrefix(w []byte, prefix string) bool {
+	return strings.HasPrefix(string(w), prefix)
+}
+
+func isVowel(c byte) bool {
+	return c != 0 && strings.IndexByte("AEIOU", c) >= 0
+}
+
+func isFrontVowel(c byte) bool {
+	return c == 'E' || c == 'I' || c == 'Y'
+}
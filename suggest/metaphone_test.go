@@ -0,0 +1,82 @@
+package suggest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMetaphonePairs(t *testing.T) {
+	pairs := [][2]string{
+		{"filips", "philips"},
+		{"smith", "smyth"},
+		{"nite", "knight"},
+		{"kat", "cat"},
+		{"Zerox", "xerox"},
+		{"fone", "phone"},
+		{"café", "cafe"},
+	}
+
+	for _, pair := range pairs {
+		a, b := metaphone(pair[0]), metaphone(pair[1])
+		if a == "" || a != b {
+			t.Errorf("metaphone(%q) = %q, metaphone(%q) = %q, want equal codes", pair[0], a, pair[1], b)
+		}
+	}
+
+	for _, pair := range [][2]string{{"smith", "smile"}, {"philips", "flip"}} {
+		if metaphone(pair[0]) == metaphone(pair[1]) {
+			t.Errorf("%q and %q sound alike: %q", pair[0], pair[1], metaphone(pair[0]))
+		}
+	}
+}
+
+func TestMetaphoneScripts(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"philips tv", "FLPS TF"},
+		{"42 smith", "SM0"},
+		{"филипс", ""},
+		{"philips телевизор", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := metaphone(tt.in); got != tt.want {
+			t.Errorf("metaphone(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPhoneticLookup(t *testing.T) {
+	const data = `[
+		{"id": "philips", "name": "Philips TV", "cost": 10},
+		{"id": "filips", "name": "Filips store", "cost": 50},
+		{"id": "smyth", "name": "Smyth & Co", "cost": 20}
+	]`
+
+	tests := []struct {
+		mode  MatchMode
+		input string
+		want  []string
+	}{
+		// textual matches rank above phonetic ones whatever their cost
+		{MatchExact, "filips", []string{"Filips store", "Philips TV"}},
+		{MatchExact, "smith", []string{"Smyth & Co"}},
+		{MatchPrefix, "filip", []string{"Filips store", "Philips TV"}},
+		// too short a code to match as a prefix
+		{MatchPrefix, "fil", []string{"Filips store"}},
+		{MatchExact, "xyz", []string{}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Mode, opts.Phonetic = tt.mode, true
+		s := load(t, opts, data)
+
+		if got := texts(s.ListByKey(context.Background(), tt.input, ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ListByKey(%q) = %q, want %q", tt.mode, tt.input, got, tt.want)
+		}
+	}
+}
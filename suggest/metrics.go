@@ -0,0 +1,70 @@
+package suggest
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metrics = newMetrics()
+
+// Collectors returns the metrics of the stores for registering along with
+// those of the embedding service.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{metrics.keys, metrics.lastLoad, metrics.loadSuccesses, metrics.loadFailures, metrics.rejectedLoads, metrics.cacheHits, metrics.cacheMisses, metrics.warmup}
+}
+
+type storeMetrics struct {
+	keys     prometheus.Gauge
+	lastLoad prometheus.Gauge
+	// loads are counted whatever triggered them, including the first one
+	loadSuccesses prometheus.Counter
+	loadFailures  prometheus.Counter
+	// loads refused for exceeding -max-keys
+	rejectedLoads prometheus.Counter
+
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+	warmup      prometheus.Gauge
+}
+
+func newMetrics() *storeMetrics {
+	return &storeMetrics{
+		keys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "suggestions_keys",
+			Help: "Number of keys currently loaded.",
+		}),
+		lastLoad: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "suggestions_last_load_timestamp_seconds",
+			Help: "Unix time of the last successful load.",
+		}),
+		loadSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suggestions_reload_success_total",
+			Help: "Number of successful loads.",
+		}),
+		loadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suggestions_reload_failures_total",
+			Help: "Number of failed loads that kept the current data.",
+		}),
+		rejectedLoads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suggestions_rejected_loads_total",
+			Help: "Number of loads refused for having too many keys.",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suggest_cache_hits_total",
+			Help: "Number of queries answered from the result cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suggest_cache_misses_total",
+			Help: "Number of queries missing the result cache.",
+		}),
+		warmup: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "suggest_cache_warmup_duration_seconds",
+			Help: "Time the last load spent warming the cache with -warm-prefixes.",
+		}),
+	}
+}
+
+func (m *storeMetrics) loaded(keys int) {
+	m.keys.Set(float64(keys))
+	m.lastLoad.SetToCurrentTime()
+	m.loadSuccesses.Inc()
+}
@@ -0,0 +1,136 @@
+package suggest
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+const ngramSize = 3
+
+// ngramIndex serves substring queries. Every trigram of every normalized
+// suggestion text maps to the sorted list of entries containing it, so a
+// query only has to verify the entries present in all of its trigrams'
+// lists. The price is memory: roughly one int per rune of every suggestion
+// text on top of the data itself. Without grams, or for queries shorter than
+// a trigram, all entries are scanned.
+type ngramIndex struct {
+	entries []ngramEntry
+	grams   map[string][]int
+}
+
+type ngramEntry struct {
+	text string
+	item Item
+}
+
+func newNgramIndex(keys []string, data map[string][]Item, normalize func(string) string, withGrams bool) *ngramIndex {
+	x := &ngramIndex{entries: make([]ngramEntry, 0, len(keys))}
+	if withGrams {
+		x.grams = make(map[string][]int)
+	}
+
+	for _, key := range keys {
+		for _, item := range data[key] {
+			idx := len(x.entries)
+			text := normalize(item.Name)
+			x.entries = append(x.entries, ngramEntry{text: text, item: item})
+
+			if x.grams == nil {
+				continue
+			}
+
+			for _, gram := range ngrams(text) {
+				postings := x.grams[gram]
+				if len(postings) > 0 && postings[len(postings)-1] == idx {
+					continue
+				}
+				x.grams[gram] = append(postings, idx)
+			}
+		}
+	}
+
+	return x
+}
+
+// search gives up with no results once ctx is done.
+func (x *ngramIndex) search(ctx context.Context, query string) []Item {
+	items := make([]Item, 0)
+
+	grams := ngrams(query)
+	if x.grams == nil || len(grams) == 0 {
+		for i, entry := range x.entries {
+			if cancelled(ctx, i) {
+				return []Item{}
+			}
+
+			if strings.Contains(entry.text, query) {
+				items = append(items, entry.item)
+			}
+		}
+
+		return items
+	}
+
+	lists := make([][]int, 0, len(grams))
+	for _, gram := range grams {
+		postings, ok := x.grams[gram]
+		if !ok {
+			return items
+		}
+		lists = append(lists, postings)
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	})
+
+	candidates := lists[0]
+	for _, list := range lists[1:] {
+		candidates = intersect(candidates, list)
+	}
+
+	for i, idx := range candidates {
+		if cancelled(ctx, i) {
+			return []Item{}
+		}
+
+		if strings.Contains(x.entries[idx].text, query) {
+			items = append(items, x.entries[idx].item)
+		}
+	}
+
+	return items
+}
+
+func ngrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < ngramSize {
+		return nil
+	}
+
+	grams := make([]string, 0, len(runes)-ngramSize+1)
+	for i := 0; i+ngramSize <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+ngramSize]))
+	}
+
+	return grams
+}
+
+func intersect(a, b []int) []int {
+	result := make([]int, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,426 @@
+package suggest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisBatch = 1000
+
+// RedisStore keeps suggestions in Redis so that several instances can share
+// one dataset. Every key is a sorted set of JSON-encoded items scored by
+// cost, and a lexicographically sorted set of all keys serves prefix
+// lookups. Each load writes a new generation of keys and then switches the
+// generation pointer, so readers never see a half-written dataset. In merge
+// reload mode the data is instead added to the current generation in a
+// single transaction.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	opts   MapOptions
+	// parser is only used to decode data files and to share normalization
+	// and ranking rules with SuggestionsMap
+	parser *SuggestionsMap
+
+	loadTracker
+}
+
+type redisMember struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Category string          `json:"category,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+func NewRedisStore(addr, prefix string, opts MapOptions) *RedisStore {
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		prefix: prefix,
+		opts:   opts,
+		parser: New(opts),
+	}
+}
+
+func (s *RedisStore) Load(path string) error {
+	if err := s.load(path); err != nil {
+		s.failed(path, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *RedisStore) load(path string) error {
+	data, err := s.parser.read(path)
+	if err != nil {
+		return err
+	}
+	conflicts := s.parser.resolve(data)
+
+	synonyms, err := s.parser.readSynonyms()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	old, err := s.generation(ctx)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	if s.opts.Reload == ReloadMerge && old != "" {
+		if err := s.merge(ctx, old, data, conflicts); err != nil {
+			return err
+		}
+		s.parser.setSynonyms(synonyms)
+		return nil
+	}
+
+	if err := checkKeys(len(data), s.opts.MaxKeys); err != nil {
+		return err
+	}
+
+	gen := strconv.FormatInt(time.Now().UnixNano(), 10)
+	pipe := s.client.Pipeline()
+	total := 0
+	for key, items := range data {
+		if _, err := s.add(ctx, pipe, gen, key, items); err != nil {
+			return err
+		}
+		total += len(items)
+
+		if pipe.Len() >= redisBatch {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if err := s.client.Set(ctx, s.generationKey(), gen, 0).Err(); err != nil {
+		return err
+	}
+	s.parser.setSynonyms(synonyms)
+	s.succeeded(len(data), total, conflicts)
+
+	if old != "" {
+		s.drop(ctx, old)
+	}
+
+	return nil
+}
+
+// merge adds data to the gen generation. Members are the JSON of id, name,
+// category and payload, so an item equal to a stored one apart from cost
+// replaces it.
+func (s *RedisStore) merge(ctx context.Context, gen string, data map[string][]Item, conflicts int) error {
+	if s.opts.MaxKeys > 0 {
+		keys, err := s.mergedKeys(ctx, gen, data)
+		if err != nil {
+			return err
+		}
+
+		if err := checkKeys(keys, s.opts.MaxKeys); err != nil {
+			return err
+		}
+	}
+
+	pipe := s.client.TxPipeline()
+	added := make([]*redis.IntCmd, 0, len(data))
+	for key, items := range data {
+		cmd, err := s.add(ctx, pipe, gen, key, items)
+		if err != nil {
+			return err
+		}
+		added = append(added, cmd)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	n, err := s.client.ZCard(ctx, s.keysKey(gen)).Result()
+	if err != nil {
+		return err
+	}
+
+	// ZADD counts only new members, replaced ones are already in the total
+	total := s.Stats().Items
+	for _, cmd := range added {
+		total += int(cmd.Val())
+	}
+	s.succeeded(int(n), total, conflicts)
+
+	return nil
+}
+
+// mergedKeys counts the keys gen would have with data merged in.
+func (s *RedisStore) mergedKeys(ctx context.Context, gen string, data map[string][]Item) (int, error) {
+	pipe := s.client.Pipeline()
+	card := pipe.ZCard(ctx, s.keysKey(gen))
+	scores := make([]*redis.FloatCmd, 0, len(data))
+	for key := range data {
+		scores = append(scores, pipe.ZScore(ctx, s.keysKey(gen), key))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	keys := int(card.Val())
+	for _, cmd := range scores {
+		if cmd.Err() == redis.Nil {
+			keys++
+		}
+	}
+
+	return keys, nil
+}
+
+// add queues the items of key and returns the command counting the items
+// that were new to it.
+func (s *RedisStore) add(ctx context.Context, pipe redis.Pipeliner, gen, key string, items []Item) (*redis.IntCmd, error) {
+	members := make([]redis.Z, 0, len(items))
+	for _, item := range items {
+		member, err := json.Marshal(redisMember{ID: item.ID, Name: item.Name, Category: item.Category, Payload: item.Payload})
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, redis.Z{Score: float64(item.Cost), Member: member})
+	}
+
+	cmd := pipe.ZAdd(ctx, s.itemsKey(gen, key), members...)
+	pipe.ZAdd(ctx, s.keysKey(gen), redis.Z{Member: key})
+
+	return cmd, nil
+}
+
+func (s *RedisStore) ListByKey(ctx context.Context, key string, opts ListOptions) []Suggestion {
+	key = s.parser.normalize(key)
+	also := s.parser.normalizeAll(opts.Also)
+
+	gen, err := s.generation(ctx)
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		return []Suggestion{}
+	}
+
+	// popular suggestions aren't kept in Redis
+	if key == "" && len(also) == 0 {
+		return []Suggestion{}
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = s.opts.Mode
+	}
+
+	keys, err := s.keys(ctx, gen, s.parser.variants(key, also), mode)
+	if err != nil {
+		log.Println(err)
+		return []Suggestion{}
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.ZSliceCmd, 0, len(keys))
+	for _, k := range keys {
+		cmds = append(cmds, pipe.ZRangeWithScores(ctx, s.itemsKey(gen, k), 0, -1))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Println(err)
+		return []Suggestion{}
+	}
+
+	items := make([]Item, 0)
+	for i, cmd := range cmds {
+		items = s.decode(items, keys[i], cmd.Val())
+	}
+	items = opts.filter(items)
+	s.rank(items)
+
+	list := toSuggestions(s.parser.dedup(items), key, mode)
+	if s.parser.sampler != nil {
+		s.parser.sampler.shuffle(list, opts.Seed)
+	}
+	numberFrom(list, s.opts.PositionBase)
+
+	return list
+}
+
+// keys returns the distinct keys matching any of the variants of a query.
+// Keys are only indexed by prefix, so substring queries are refused rather
+// than answered with prefix matches.
+func (s *RedisStore) keys(ctx context.Context, gen string, variants []string, mode MatchMode) ([]string, error) {
+	switch mode {
+	case MatchExact:
+		return variants, nil
+	case MatchSubstring:
+		return nil, fmt.Errorf("the Redis store doesn't support %s matching", mode)
+	}
+
+	keys := make([]string, 0)
+	for _, variant := range variants {
+		matches, err := s.client.ZRangeByLex(ctx, s.keysKey(gen), &redis.ZRangeBy{
+			Min: "[" + variant,
+			Max: "[" + variant + "\xff",
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range matches {
+			if !contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *RedisStore) Items(ctx context.Context, key string) []Item {
+	key = s.parser.normalize(key)
+
+	gen, err := s.generation(ctx)
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		return nil
+	}
+
+	members, err := s.client.ZRangeWithScores(ctx, s.itemsKey(gen, key), 0, -1).Result()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+
+	items := s.decode(make([]Item, 0, len(members)), key, members)
+	s.rank(items)
+
+	return items
+}
+
+func (s *RedisStore) Keys(ctx context.Context, prefix string, offset, limit int) ([]string, int) {
+	prefix = s.parser.normalize(prefix)
+
+	gen, err := s.generation(ctx)
+	if err != nil {
+		if err != redis.Nil {
+			log.Println(err)
+		}
+		return nil, 0
+	}
+
+	from, to := "-", "+"
+	if prefix != "" {
+		from, to = "["+prefix, "["+prefix+"\xff"
+	}
+
+	pipe := s.client.Pipeline()
+	keys := pipe.ZRangeByLex(ctx, s.keysKey(gen), &redis.ZRangeBy{Min: from, Max: to, Offset: int64(offset), Count: int64(limit)})
+	total := pipe.ZLexCount(ctx, s.keysKey(gen), from, to)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println(err)
+		return nil, 0
+	}
+
+	return keys.Val(), int(total.Val())
+}
+
+// decode appends the items of key stored as members to items.
+func (s *RedisStore) decode(items []Item, key string, members []redis.Z) []Item {
+	for _, z := range members {
+		var member redisMember
+		if err := json.Unmarshal([]byte(z.Member.(string)), &member); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		item := Item{Key: key, ID: member.ID, Name: member.Name, Category: member.Category, Payload: member.Payload, Cost: int(z.Score)}
+		item.Score = s.parser.score(item)
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func (s *RedisStore) rank(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.parser.less(items[i], items[j])
+	})
+}
+
+func (s *RedisStore) Len() int {
+	ctx := context.Background()
+	gen, err := s.generation(ctx)
+	if err != nil {
+		return 0
+	}
+
+	n, err := s.client.ZCard(ctx, s.keysKey(gen)).Result()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+
+	return int(n)
+}
+
+func (s *RedisStore) Loaded() bool {
+	_, err := s.generation(context.Background())
+	return err == nil
+}
+
+func (s *RedisStore) drop(ctx context.Context, gen string) {
+	iter := s.client.Scan(ctx, 0, s.prefix+":"+gen+":*", redisBatch).Iterator()
+	keys := make([]string, 0, redisBatch)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == redisBatch {
+			s.client.Unlink(ctx, keys...)
+			keys = keys[:0]
+		}
+	}
+
+	if len(keys) > 0 {
+		s.client.Unlink(ctx, keys...)
+	}
+
+	if err := iter.Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *RedisStore) generation(ctx context.Context) (string, error) {
+	return s.client.Get(ctx, s.generationKey()).Result()
+}
+
+func (s *RedisStore) generationKey() string {
+	return s.prefix + ":generation"
+}
+
+func (s *RedisStore) keysKey(gen string) string {
+	return s.prefix + ":" + gen + ":keys"
+}
+
+func (s *RedisStore) itemsKey(gen, key string) string {
+	return s.prefix + ":" + gen + ":items:" + key
+}
@@ -0,0 +1,60 @@
+package suggest
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// sampler reorders results by weighted random sampling without replacement,
+// an item's chance to come first being proportional to its cost. Items of
+// cost zero or less come last in their ranked order.
+type sampler struct {
+	mx  sync.Mutex
+	rng *rand.Rand
+}
+
+// newSampler seeds the shared generator with seed, or randomly if it is 0.
+func newSampler(seed int64) *sampler {
+	if seed == 0 {
+		seed = rand.Int64()
+	}
+
+	return &sampler{rng: rand.New(rand.NewPCG(uint64(seed), 0))}
+}
+
+// shuffle reorders list in place, drawing from a generator of its own when
+// seed is set so that the order is reproducible.
+func (s *sampler) shuffle(list []Suggestion, seed *int64) {
+	rng := s.rng
+	if seed != nil {
+		rng = rand.New(rand.NewPCG(uint64(*seed), 0))
+	} else {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+	}
+
+	// Efraimidis-Spirakis: sorting by u^(1/w) samples proportionally to w,
+	// compared as logarithms to keep precision for big weights
+	keys := make([]float64, len(list))
+	order := make([]int, len(list))
+	for i := range list {
+		order[i] = i
+		keys[i] = math.Inf(-1)
+		if w := float64(*list[i].Cost); w > 0 {
+			keys[i] = math.Log(rng.Float64()) / w
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]] > keys[order[j]]
+	})
+
+	sampled := make([]Suggestion, len(list))
+	for i, j := range order {
+		sampled[i] = list[j]
+		sampled[i].Position = i
+	}
+	copy(list, sampled)
+}
@@ -0,0 +1,134 @@
+package suggest
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// snapshotVersion must be bumped on every change of the snapshot layout or
+// of the Item fields, so that stale snapshots are refused instead of being
+// misread.
+const snapshotVersion = 1
+
+// snapshotSizeHint caps the map preallocated for a snapshot, whose key count
+// is only trusted as far as there are keys to read.
+const snapshotSizeHint = 1 << 16
+
+type snapshotHeader struct {
+	Version int
+	// keys are stored normalized, so they are only valid under the same rules
+	Normalization string
+	Keys          int
+}
+
+// Snapshot writes the current dataset to w as a gob stream that LoadSnapshot
+// reads back much faster than the data files are parsed.
+func (s *SuggestionsMap) Snapshot(w io.Writer) error {
+	// loads swap in a new index rather than modifying the current one, so
+	// it can be encoded while they run
+	data := s.current().data
+
+	enc := gob.NewEncoder(w)
+	header := snapshotHeader{Version: snapshotVersion, Normalization: s.normalization(), Keys: len(data)}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	for key, items := range data {
+		if err := enc.Encode(key); err != nil {
+			return err
+		}
+
+		if err := enc.Encode(items); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot replaces the dataset with a snapshot written by Snapshot.
+// Scores are recomputed and keys re-ranked, so the ranking options may differ
+// from those the snapshot was taken with, but the normalization ones may not.
+func (s *SuggestionsMap) LoadSnapshot(path string) error {
+	data, err := s.readSnapshot(path)
+	if err != nil {
+		s.failed(path, err)
+		return err
+	}
+
+	for _, items := range data {
+		for i := range items {
+			items[i].Score = s.score(items[i])
+		}
+	}
+
+	s.loadMx.Lock()
+	defer s.loadMx.Unlock()
+
+	if err := checkKeys(len(data), s.opts.MaxKeys); err != nil {
+		s.failed(path, err)
+		return err
+	}
+
+	// synonyms aren't part of the snapshot, they are read as on every load
+	synonyms, err := s.readSynonyms()
+	if err != nil {
+		s.failed(path, err)
+		return err
+	}
+	s.setSynonyms(synonyms)
+
+	s.rank(data)
+	s.swap(data, 0)
+	return nil
+}
+
+func (s *SuggestionsMap) readSnapshot(path string) (map[string][]Item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("%s: snapshot version %d, expected %d", path, header.Version, snapshotVersion)
+	}
+
+	if header.Normalization != s.normalization() {
+		return nil, fmt.Errorf("%s: snapshot normalized with %s, expected %s", path, header.Normalization, s.normalization())
+	}
+
+	if header.Keys < 0 {
+		return nil, fmt.Errorf("%s: snapshot has %d keys", path, header.Keys)
+	}
+
+	data := make(map[string][]Item, min(header.Keys, snapshotSizeHint))
+	for i := 0; i < header.Keys; i++ {
+		var key string
+		var items []Item
+		if err := dec.Decode(&key); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if err := dec.Decode(&items); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		data[key] = items
+	}
+
+	return data, nil
+}
+
+func (s *SuggestionsMap) normalization() string {
+	return fmt.Sprintf("case-insensitive=%v fold-diacritics=%v", s.opts.CaseInsensitive, s.opts.FoldDiacritics)
+}
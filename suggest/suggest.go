@@ -0,0 +1,1360 @@
+// Package suggest is the suggestion engine behind the HTTP service. It loads
+// data files into an in-memory SuggestionsMap or a RedisStore shared by
+// several processes and ranks the suggestions matching a query, so that it
+// can be embedded without running the server:
+//
+//	store := suggest.New(suggest.DefaultOptions())
+//	if err := store.Load("suggestions.json"); err != nil {
+//		log.Fatal(err)
+//	}
+//	list := store.ListByKey(ctx, "hel", suggest.ListOptions{})
+//
+// Stores export their metrics through Collectors.
+package suggest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// storage
+
+// Store is implemented by SuggestionsMap and RedisStore. Both are safe for
+// concurrent use, including queries running during a Load.
+type Store interface {
+	Load(path string) error
+	ListByKey(ctx context.Context, key string, opts ListOptions) []Suggestion
+	// Items returns the items stored under exactly key in ranked order.
+	Items(ctx context.Context, key string) []Item
+	// Keys returns a page of the normalized keys starting with prefix in
+	// lexicographic order along with their total count.
+	Keys(ctx context.Context, prefix string, offset, limit int) ([]string, int)
+	Len() int
+	Loaded() bool
+	Stats() LoadStats
+}
+
+type LoadStats struct {
+	LastLoad  *time.Time `json:"last_load"`
+	Keys      int        `json:"keys"`
+	Items     int        `json:"items"`
+	Conflicts int        `json:"conflicts"`
+	Failed    bool       `json:"failed"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// loadTracker records the outcome of loads for the admin stats.
+type loadTracker struct {
+	mx    sync.Mutex
+	stats LoadStats
+}
+
+func (t *loadTracker) succeeded(keys, items, conflicts int) {
+	now := time.Now()
+
+	t.mx.Lock()
+	t.stats = LoadStats{LastLoad: &now, Keys: keys, Items: items, Conflicts: conflicts}
+	t.mx.Unlock()
+
+	metrics.loaded(keys)
+}
+
+// failed records a load of path that failed and left the current data in
+// place. Only the first of repeated failures with the same error is logged.
+func (t *loadTracker) failed(path string, err error) {
+	t.mx.Lock()
+	repeated := t.stats.Failed && t.stats.LastError == err.Error()
+	t.stats.Failed = true
+	t.stats.LastError = err.Error()
+	t.mx.Unlock()
+
+	metrics.loadFailures.Inc()
+	if !repeated {
+		log.Printf("warning: loading %s failed, keeping the current data: %v", path, err)
+	}
+}
+
+func (t *loadTracker) Stats() LoadStats {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.stats
+}
+
+type MatchMode string
+
+const (
+	MatchExact     MatchMode = "exact"
+	MatchPrefix    MatchMode = "prefix"
+	MatchSubstring MatchMode = "substring"
+
+	// reported for fuzzy fallback and phonetic matches only, they can't be
+	// requested
+	matchFuzzy    MatchMode = "fuzzy"
+	matchPhonetic MatchMode = "phonetic"
+)
+
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch mode := MatchMode(s); mode {
+	case MatchExact, MatchPrefix, MatchSubstring:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown match mode %q", s)
+	}
+}
+
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch order := SortOrder(s); order {
+	case SortAsc, SortDesc:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown sort order %q", s)
+	}
+}
+
+// TieBreak is the field ordering items of equal score, so that the ranking
+// doesn't depend on the order of the data file.
+type TieBreak string
+
+const (
+	TieBreakName TieBreak = "name"
+	TieBreakID   TieBreak = "id"
+)
+
+func ParseTieBreak(s string) (TieBreak, error) {
+	switch tb := TieBreak(s); tb {
+	case TieBreakName, TieBreakID:
+		return tb, nil
+	default:
+		return "", fmt.Errorf("unknown tie-break %q", s)
+	}
+}
+
+type ReloadMode string
+
+const (
+	ReloadReplace ReloadMode = "replace"
+	ReloadMerge   ReloadMode = "merge"
+)
+
+func ParseReloadMode(s string) (ReloadMode, error) {
+	switch mode := ReloadMode(s); mode {
+	case ReloadReplace, ReloadMerge:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown reload mode %q", s)
+	}
+}
+
+type ConflictPolicy string
+
+const (
+	ConflictAppend          ConflictPolicy = "append"
+	ConflictKeepFirst       ConflictPolicy = "keep-first"
+	ConflictKeepHighestCost ConflictPolicy = "keep-highest-cost"
+	ConflictSumCost         ConflictPolicy = "sum-cost"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case ConflictAppend, ConflictKeepFirst, ConflictKeepHighestCost, ConflictSumCost:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", s)
+	}
+}
+
+type MapOptions struct {
+	Mode            MatchMode
+	Reload          ReloadMode
+	Conflicts       ConflictPolicy
+	CaseInsensitive bool
+	FoldDiacritics  bool
+	FuzzyDistance   int
+	FuzzyPrefix     FuzzyPrefix
+	Sort            SortOrder
+	TieBreak        TieBreak
+	SubstringIndex  bool
+	Phonetic        bool // also match Latin-script keys sounding like the query
+	CSVDelimiter    rune
+	Fields          FieldMap
+	Synonyms        string // path of the synonyms file
+	CacheSize       int
+	WarmPrefixes    string // path of the prefixes cached on every load
+	MaxKeys         int
+	Popular         int // top ranked items precomputed for empty queries
+	Weights         Weights
+	Sampling        bool  // order results by weighted random sampling instead of by rank
+	SamplingSeed    int64 // 0 seeds randomly
+	PositionBase    int   // position of the top suggestion, 0 or 1
+}
+
+// DefaultOptions returns the options the service runs with unless
+// configured otherwise.
+func DefaultOptions() MapOptions {
+	return MapOptions{
+		Mode:            MatchPrefix,
+		Reload:          ReloadReplace,
+		Conflicts:       ConflictAppend,
+		CaseInsensitive: true,
+		Sort:            SortAsc,
+		TieBreak:        TieBreakName,
+		SubstringIndex:  true,
+		CSVDelimiter:    ',',
+		FuzzyPrefix:     FuzzyPrefix{FirstChar: 2},
+		Weights:         Weights{Cost: 1},
+	}
+}
+
+// FuzzyPrefix switches the fuzzy fallback from Levenshtein distance between
+// whole keys to a distance between the input and key prefixes, where an edit
+// at the first character costs FirstChar and the cost then decays towards 1.
+type FuzzyPrefix struct {
+	Enabled   bool
+	FirstChar float64
+}
+
+// Weights combine an item's cost and text length into the score it is
+// ranked by. The default of cost 1 and length 0 ranks by cost alone.
+type Weights struct {
+	Cost   float64
+	Length float64
+}
+
+type SuggestionsMap struct {
+	// serializes loads so that merges never build on a stale dataset
+	loadMx sync.Mutex
+	opts   MapOptions
+	// nil until the first load, then replaced as a whole by every load so
+	// that queries never wait for one
+	idx atomic.Pointer[index]
+	// reloaded along with the data, nil without -synonyms
+	synonyms atomic.Pointer[Synonyms]
+	// nil when caching is disabled
+	cache *lruCache
+	// nil unless Sampling is set
+	sampler *sampler
+	// collects skipped rows instead of logging them, set by Validate
+	skipped func(error)
+
+	loadTracker
+}
+
+// index is the dataset of a load along with the structures built from it.
+// It is never modified once published.
+type index struct {
+	data map[string][]Item
+	// also lists the keys by prefix
+	trie *Trie
+	// keys bucketed by rune length to narrow down fuzzy candidates
+	lengths map[int][]string
+	texts   *ngramIndex
+	// nil unless Phonetic is set
+	sounds  *phoneticIndex
+	popular []Item
+}
+
+var emptyIndex = &index{data: make(map[string][]Item), trie: NewTrie(), texts: newNgramIndex(nil, nil, nil, false)}
+
+type Item struct {
+	// normalized key the item is stored under
+	Key      string  `json:"-"`
+	ID       string  `json:"id"`
+	Cost     int     `json:"cost"`
+	Name     string  `json:"name"`
+	Category string  `json:"category,omitempty"`
+	Score    float64 `json:"score"`
+	// arbitrary JSON from the data file, e.g. a target URL
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type ListOptions struct {
+	// Mode overrides the map's default matching mode when set
+	Mode     MatchMode
+	Category *string
+	// inclusive cost bounds, nil leaving that side unbounded
+	MinCost *int
+	MaxCost *int
+	// seeds the sampling of this query alone, see MapOptions.Sampling
+	Seed *int64
+	// Also lists more keys whose matches are ranked along with those of the
+	// key, as if the queries were OR'd
+	Also []string
+}
+
+func (o ListOptions) filter(items []Item) []Item {
+	if o.Category == nil && o.MinCost == nil && o.MaxCost == nil {
+		return items
+	}
+
+	filtered := make([]Item, 0, len(items))
+	for _, item := range items {
+		if o.matches(item) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered
+}
+
+func (o ListOptions) matches(item Item) bool {
+	return (o.Category == nil || item.Category == *o.Category) &&
+		(o.MinCost == nil || item.Cost >= *o.MinCost) &&
+		(o.MaxCost == nil || item.Cost <= *o.MaxCost)
+}
+
+func New(opts MapOptions) *SuggestionsMap {
+	s := &SuggestionsMap{opts: opts}
+	if opts.CacheSize > 0 {
+		s.cache = newLRUCache(opts.CacheSize)
+	}
+
+	if opts.Sampling {
+		s.sampler = newSampler(opts.SamplingSeed)
+	}
+
+	return s
+}
+
+func (s *SuggestionsMap) Load(path string) error {
+	data, err := s.read(path)
+	if err != nil {
+		s.failed(path, err)
+		return err
+	}
+	conflicts := s.resolve(data)
+
+	s.loadMx.Lock()
+	defer s.loadMx.Unlock()
+
+	if s.opts.Reload == ReloadMerge {
+		data = s.merge(data)
+	} else {
+		s.rank(data)
+	}
+
+	if err := checkKeys(len(data), s.opts.MaxKeys); err != nil {
+		s.failed(path, err)
+		return err
+	}
+
+	synonyms, err := s.readSynonyms()
+	if err != nil {
+		s.failed(path, err)
+		return err
+	}
+	s.setSynonyms(synonyms)
+
+	s.swap(data, conflicts)
+	return nil
+}
+
+func (s *SuggestionsMap) readSynonyms() (Synonyms, error) {
+	if s.opts.Synonyms == "" {
+		return nil, nil
+	}
+
+	return loadSynonyms(s.opts.Synonyms, s.normalize)
+}
+
+func (s *SuggestionsMap) setSynonyms(synonyms Synonyms) {
+	s.synonyms.Store(&synonyms)
+}
+
+// current returns the index of the last load, an empty one before the first.
+func (s *SuggestionsMap) current() *index {
+	if idx := s.idx.Load(); idx != nil {
+		return idx
+	}
+
+	return emptyIndex
+}
+
+// variants returns the distinct non-empty normalized keys a query is looked
+// up by: key, the keys it is OR'd with and all their synonym variants.
+func (s *SuggestionsMap) variants(key string, also []string) []string {
+	var synonyms Synonyms
+	if p := s.synonyms.Load(); p != nil {
+		synonyms = *p
+	}
+
+	variants := make([]string, 0, 1+len(also))
+	for _, k := range append([]string{key}, also...) {
+		for _, variant := range synonyms.expand(k) {
+			if variant != "" && !contains(variants, variant) {
+				variants = append(variants, variant)
+			}
+		}
+	}
+
+	return variants
+}
+
+func (s *SuggestionsMap) normalizeAll(keys []string) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(keys))
+	for _, key := range keys {
+		normalized = append(normalized, s.normalize(key))
+	}
+
+	return normalized
+}
+
+// read loads path, which may be a comma-separated list of files and glob
+// patterns merged into one dataset. A file failing to load is logged and
+// skipped unless no file loads at all.
+func (s *SuggestionsMap) read(path string) (map[string][]Item, error) {
+	files, err := dataFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string][]Item
+	for _, file := range files {
+		fileData, e := s.readFile(file)
+		if e != nil {
+			if len(files) > 1 {
+				log.Printf("skipping data file: %v", e)
+			}
+			err = e
+			continue
+		}
+
+		if data == nil {
+			data = fileData
+			continue
+		}
+
+		for key, items := range fileData {
+			data[key] = append(data[key], items...)
+		}
+	}
+
+	if data == nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SuggestionsMap) readFile(path string) (map[string][]Item, error) {
+	r, err := openData(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decode := s.decode
+	if isCSV(path) {
+		decode = s.decodeCSV
+	}
+
+	data, err := decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return data, nil
+}
+
+func (s *SuggestionsMap) decode(r io.Reader) (map[string][]Item, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array of suggestions")
+	}
+
+	data := make(map[string][]Item)
+	for dec.More() {
+		var dto suggestionDTO
+		if err := s.decodeDTO(dec, &dto); err != nil {
+			return nil, err
+		}
+
+		s.add(data, dto)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SuggestionsMap) decodeDTO(dec *json.Decoder, dto *suggestionDTO) error {
+	if len(s.opts.Fields) == 0 {
+		return dec.Decode(dto)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	return s.opts.Fields.decode(raw, dto)
+}
+
+func (s *SuggestionsMap) ListByKey(ctx context.Context, key string, opts ListOptions) []Suggestion {
+	key = s.normalize(key)
+	opts.Also = s.normalizeAll(opts.Also)
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = s.opts.Mode
+	}
+
+	list := s.cachedList(ctx, key, mode, opts)
+	// sampled after caching, so that cached results keep being resampled
+	if s.sampler != nil {
+		s.sampler.shuffle(list, opts.Seed)
+	}
+	numberFrom(list, s.opts.PositionBase)
+
+	return list
+}
+
+func (s *SuggestionsMap) cachedList(ctx context.Context, key string, mode MatchMode, opts ListOptions) []Suggestion {
+	if s.cache == nil {
+		return s.list(ctx, key, mode, opts)
+	}
+
+	cacheKey := cacheKey(key, mode, opts)
+	if list, ok := s.cache.get(cacheKey); ok {
+		metrics.cacheHits.Inc()
+		return list
+	}
+	metrics.cacheMisses.Inc()
+
+	gen := s.cache.generation()
+	list := s.list(ctx, key, mode, opts)
+	if ctx.Err() == nil {
+		s.cache.add(gen, cacheKey, list)
+	}
+
+	return list
+}
+
+func (s *SuggestionsMap) list(ctx context.Context, key string, mode MatchMode, opts ListOptions) []Suggestion {
+	// a query sees a single load even if another one completes meanwhile
+	idx := s.current()
+	if key == "" && len(opts.Also) == 0 {
+		return toSuggestions(opts.filter(idx.popular), key, MatchPrefix)
+	}
+
+	var items []Item
+	variants := s.variants(key, opts.Also)
+	for _, variant := range variants {
+		items = append(items, s.match(ctx, idx, variant, mode)...)
+	}
+
+	if len(variants) > 1 {
+		sort.SliceStable(items, func(i, j int) bool {
+			return s.less(items[i], items[j])
+		})
+	}
+	items = opts.filter(items)
+
+	var sounds []Item
+	if s.opts.Phonetic {
+		sounds = opts.filter(s.listByPhonetic(idx, variants, mode))
+	}
+
+	if len(items) == 0 && len(sounds) == 0 && s.opts.FuzzyDistance > 0 {
+		items = opts.filter(s.listByFuzzy(ctx, idx, key))
+		mode = matchFuzzy
+	}
+
+	items = s.dedup(items)
+	suggestions := toSuggestions(items, key, mode)
+	if len(sounds) == 0 {
+		return suggestions
+	}
+
+	// phonetic matches rank below the textual ones
+	for _, suggestion := range toSuggestions(s.dedup(append(items, sounds...))[len(items):], key, matchPhonetic) {
+		suggestion.Position = len(suggestions)
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions
+}
+
+// match returns the ranked items matching the normalized key by mode.
+func (s *SuggestionsMap) match(ctx context.Context, idx *index, key string, mode MatchMode) []Item {
+	switch mode {
+	case MatchExact:
+		return idx.data[key]
+	case MatchSubstring:
+		return s.listBySubstring(ctx, idx, key)
+	default:
+		return s.listByPrefix(idx, key)
+	}
+}
+
+// numberFrom shifts the 0-based positions of list to start at base. Callers
+// slice pages off afterwards, so positions stay ranks among all matches.
+func numberFrom(list []Suggestion, base int) {
+	if base == 0 {
+		return
+	}
+
+	for i := range list {
+		list[i].Position += base
+	}
+}
+
+// toSuggestions converts items found for the normalized query key by mode.
+func toSuggestions(items []Item, key string, mode MatchMode) []Suggestion {
+	suggestions := make([]Suggestion, 0, len(items))
+	for i := range items {
+		cost, category, score := items[i].Cost, items[i].Category, items[i].Score
+		matchedKey, matchType := items[i].Key, string(mode)
+		if mode == MatchPrefix && matchedKey == key {
+			matchType = string(MatchExact)
+		}
+
+		suggestions = append(suggestions, Suggestion{
+			Position:   i,
+			Text:       items[i].Name,
+			Cost:       &cost,
+			Category:   &category,
+			Score:      &score,
+			MatchedKey: &matchedKey,
+			MatchType:  &matchType,
+			Payload:    items[i].Payload,
+		})
+	}
+
+	return suggestions
+}
+
+func (s *SuggestionsMap) listByPrefix(idx *index, prefix string) []Item {
+	items := idx.trie.Prefix(prefix)
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.less(items[i], items[j])
+	})
+
+	return items
+}
+
+func (s *SuggestionsMap) listBySubstring(ctx context.Context, idx *index, query string) []Item {
+	items := idx.texts.search(ctx, query)
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.less(items[i], items[j])
+	})
+
+	return items
+}
+
+// listByPhonetic returns the ranked items of the keys sounding like one of
+// the variants, or in prefix mode like their beginning.
+func (s *SuggestionsMap) listByPhonetic(idx *index, variants []string, mode MatchMode) []Item {
+	items := make([]Item, 0)
+	for _, variant := range variants {
+		items = append(items, idx.sounds.search(metaphone(variant), mode == MatchPrefix)...)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.less(items[i], items[j])
+	})
+
+	return items
+}
+
+func (s *SuggestionsMap) listByFuzzy(ctx context.Context, idx *index, key string) []Item {
+	type candidate struct {
+		item     Item
+		distance float64
+	}
+
+	candidates := make([]candidate, 0)
+	for _, k := range s.fuzzyKeys(ctx, idx, key) {
+		for _, item := range idx.data[k.key] {
+			candidates = append(candidates, candidate{item: item, distance: k.distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+
+		return s.less(candidates[i].item, candidates[j].item)
+	})
+
+	items := make([]Item, 0, len(candidates))
+	for _, c := range candidates {
+		items = append(items, c.item)
+	}
+
+	return items
+}
+
+// DidYouMean returns up to n keys differing from the query but within the
+// fuzzy distance of it, closest first, as corrections of a misspelled query.
+func (s *SuggestionsMap) DidYouMean(ctx context.Context, key string, n int) []string {
+	if s.opts.FuzzyDistance <= 0 || n <= 0 {
+		return nil
+	}
+	key = s.normalize(key)
+	idx := s.current()
+
+	keys := make([]fuzzyKey, 0)
+	for _, k := range s.fuzzyKeys(ctx, idx, key) {
+		if k.distance > 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	// equally close keys are ordered by their top item
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].distance != keys[j].distance {
+			return keys[i].distance < keys[j].distance
+		}
+
+		a, b := idx.data[keys[i].key][0], idx.data[keys[j].key][0]
+		if s.less(a, b) != s.less(b, a) {
+			return s.less(a, b)
+		}
+
+		return keys[i].key < keys[j].key
+	})
+
+	corrections := make([]string, 0, min(n, len(keys)))
+	for _, k := range keys[:min(n, len(keys))] {
+		corrections = append(corrections, k.key)
+	}
+
+	return corrections
+}
+
+// fuzzyKey is a key within the fuzzy distance of a query.
+type fuzzyKey struct {
+	key      string
+	distance float64
+}
+
+// fuzzyKeys returns the keys of idx within the fuzzy distance of the
+// normalized key in no particular order, none once ctx is done.
+func (s *SuggestionsMap) fuzzyKeys(ctx context.Context, idx *index, key string) []fuzzyKey {
+	limit := float64(s.opts.FuzzyDistance)
+	match := func(k string) float64 { return float64(FuzzyMatch(key, k)) }
+	if s.opts.FuzzyPrefix.Enabled {
+		match = func(k string) float64 { return FuzzyPrefixMatch(key, k, s.opts.FuzzyPrefix.FirstChar, limit) }
+	}
+
+	keys := make([]fuzzyKey, 0)
+	length := utf8.RuneCountInString(key)
+	for l, bucket := range idx.lengths {
+		// a prefix may be followed by any number of characters
+		if l < length-s.opts.FuzzyDistance || l > length+s.opts.FuzzyDistance && !s.opts.FuzzyPrefix.Enabled {
+			continue
+		}
+
+		for i, k := range bucket {
+			if cancelled(ctx, i) {
+				return nil
+			}
+
+			if distance := match(k); distance <= limit {
+				keys = append(keys, fuzzyKey{key: k, distance: distance})
+			}
+		}
+	}
+
+	return keys
+}
+
+// dedup drops items repeating the text of a higher ranked one.
+func (s *SuggestionsMap) dedup(items []Item) []Item {
+	seen := make(map[string]bool, len(items))
+	unique := make([]Item, 0, len(items))
+	for _, item := range items {
+		text := s.normalize(item.Name)
+		if seen[text] {
+			continue
+		}
+
+		seen[text] = true
+		unique = append(unique, item)
+	}
+
+	return unique
+}
+
+// normalize maps key to the form it is stored and looked up by: trimmed,
+// with every run of whitespace collapsed to a single space, then folded
+// according to the options.
+func (s *SuggestionsMap) normalize(key string) string {
+	key = strings.Join(strings.Fields(key), " ")
+	if s.opts.FoldDiacritics {
+		key = foldDiacritics(key)
+	}
+	if s.opts.CaseInsensitive {
+		key = strings.ToLower(key)
+	}
+
+	return key
+}
+
+// LoadFrom replaces the dataset with a JSON or, with csv, a CSV data file,
+// optionally gzipped, read from r instead of the configured path, e.g. one
+// pushed over HTTP. Nothing is swapped in unless all of it parses.
+func (s *SuggestionsMap) LoadFrom(r io.Reader, csv bool) error {
+	data, err := s.validate(r, csv)
+	if err == nil {
+		err = s.init(data)
+	}
+
+	if err != nil {
+		s.failed("pushed data", err)
+		return err
+	}
+
+	return nil
+}
+
+// init replaces the dataset with data regardless of the reload mode.
+func (s *SuggestionsMap) init(data map[string][]Item) error {
+	conflicts := s.resolve(data)
+
+	s.loadMx.Lock()
+	defer s.loadMx.Unlock()
+
+	if err := checkKeys(len(data), s.opts.MaxKeys); err != nil {
+		return err
+	}
+
+	s.rank(data)
+	s.swap(data, conflicts)
+	return nil
+}
+
+// merge returns delta applied on top of the current dataset. An incoming item
+// replaces a stored one with the same id, name and category, so applying the
+// same delta twice is harmless; other items are appended and the touched
+// keys are re-ranked. The current slices are never modified in place since
+// readers may still hold them.
+func (s *SuggestionsMap) merge(delta map[string][]Item) map[string][]Item {
+	current := s.current().data
+	data := make(map[string][]Item, len(current)+len(delta))
+	for key, items := range current {
+		data[key] = items
+	}
+
+	touched := make(map[string][]Item, len(delta))
+	for key, items := range delta {
+		merged := make([]Item, 0, len(data[key])+len(items))
+		for _, old := range data[key] {
+			if !containsItem(items, old) {
+				merged = append(merged, old)
+			}
+		}
+		merged = append(merged, items...)
+		data[key], touched[key] = merged, merged
+	}
+
+	s.rank(touched)
+	return data
+}
+
+// checkKeys refuses datasets with more than max keys, 0 meaning no limit, to
+// protect memory from a broken export.
+func checkKeys(keys, max int) error {
+	if max <= 0 || keys <= max {
+		return nil
+	}
+
+	metrics.rejectedLoads.Inc()
+	return fmt.Errorf("data has %d keys, more than the limit of %d; keeping the current data", keys, max)
+}
+
+func containsItem(items []Item, item Item) bool {
+	for _, it := range items {
+		if it.ID == item.ID && it.Name == item.Name && it.Category == item.Category {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *SuggestionsMap) add(data map[string][]Item, dto suggestionDTO) {
+	item := Item{
+		ID:       dto.ID,
+		Cost:     dto.Cost,
+		Name:     dto.Name,
+		Category: dto.Category,
+	}
+	if string(dto.Payload) != "null" {
+		item.Payload = dto.Payload
+	}
+	item.Score = s.score(item)
+
+	item.Key = s.normalize(dto.ID)
+	data[item.Key] = append(data[item.Key], item)
+}
+
+func (s *SuggestionsMap) score(item Item) float64 {
+	w := s.opts.Weights
+	return w.Cost*float64(item.Cost) + w.Length*float64(utf8.RuneCountInString(item.Name))
+}
+
+// resolve applies the conflict policy to items sharing both id and name
+// within a key and returns the number of duplicates merged away.
+func (s *SuggestionsMap) resolve(data map[string][]Item) int {
+	if s.opts.Conflicts == "" || s.opts.Conflicts == ConflictAppend {
+		return 0
+	}
+
+	type pair struct{ id, name string }
+
+	conflicts := 0
+	for key, items := range data {
+		if len(items) < 2 {
+			continue
+		}
+
+		seen := make(map[pair]int, len(items))
+		unique := items[:0]
+		for _, item := range items {
+			i, ok := seen[pair{item.ID, item.Name}]
+			if !ok {
+				seen[pair{item.ID, item.Name}] = len(unique)
+				unique = append(unique, item)
+				continue
+			}
+
+			conflicts++
+			switch s.opts.Conflicts {
+			case ConflictKeepHighestCost:
+				if item.Cost > unique[i].Cost {
+					unique[i] = item
+				}
+			case ConflictSumCost:
+				unique[i].Cost += item.Cost
+				unique[i].Score = s.score(unique[i])
+			}
+		}
+		data[key] = unique
+	}
+
+	return conflicts
+}
+
+func (s *SuggestionsMap) less(a, b Item) bool {
+	if a.Score != b.Score {
+		if s.opts.Sort == SortDesc {
+			return a.Score > b.Score
+		}
+		return a.Score < b.Score
+	}
+
+	// ties always go in ascending order of the tie-break field, then of the
+	// other one and the category, so only fully equal items keep file order
+	first, second := [2]string{a.Name, b.Name}, [2]string{a.ID, b.ID}
+	if s.opts.TieBreak == TieBreakID {
+		first, second = second, first
+	}
+
+	switch {
+	case first[0] != first[1]:
+		return first[0] < first[1]
+	case second[0] != second[1]:
+		return second[0] < second[1]
+	default:
+		return a.Category < b.Category
+	}
+}
+
+func (s *SuggestionsMap) rank(data map[string][]Item) {
+	for _, items := range data {
+		sort.SliceStable(items, func(i, j int) bool {
+			return s.less(items[i], items[j])
+		})
+	}
+}
+
+func (s *SuggestionsMap) swap(data map[string][]Item, conflicts int) {
+	keys := make([]string, 0, len(data))
+	lengths := make(map[int][]string)
+	items := 0
+	for key := range data {
+		items += len(data[key])
+		keys = append(keys, key)
+		l := utf8.RuneCountInString(key)
+		lengths[l] = append(lengths[l], key)
+	}
+	sort.Strings(keys)
+	texts := newNgramIndex(keys, data, s.normalize, s.opts.SubstringIndex)
+
+	trie := NewTrie()
+	for _, key := range keys {
+		trie.Insert(key, data[key])
+	}
+	popular := s.top(keys, data, items)
+
+	var sounds *phoneticIndex
+	if s.opts.Phonetic {
+		sounds = newPhoneticIndex(keys, data)
+	}
+
+	s.idx.Store(&index{
+		data:    data,
+		trie:    trie,
+		lengths: lengths,
+		texts:   texts,
+		sounds:  sounds,
+		popular: popular,
+	})
+
+	if s.cache != nil {
+		s.cache.purge()
+		s.warm()
+	}
+
+	s.succeeded(len(keys), items, conflicts)
+}
+
+// top returns the best ranked distinct texts of all keys for empty queries.
+func (s *SuggestionsMap) top(keys []string, data map[string][]Item, items int) []Item {
+	if s.opts.Popular <= 0 {
+		return nil
+	}
+
+	all := make([]Item, 0, items)
+	for _, key := range keys {
+		all = append(all, data[key]...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return s.less(all[i], all[j])
+	})
+
+	all = s.dedup(all)
+	if len(all) > s.opts.Popular {
+		all = all[:s.opts.Popular]
+	}
+
+	// copied so that the full list can be collected
+	return append([]Item(nil), all...)
+}
+
+func (s *SuggestionsMap) Items(ctx context.Context, key string) []Item {
+	items, ok := s.current().data[s.normalize(key)]
+	if !ok {
+		return nil
+	}
+
+	return append([]Item{}, items...)
+}
+
+func (s *SuggestionsMap) Keys(ctx context.Context, prefix string, offset, limit int) ([]string, int) {
+	return s.current().trie.Keys(s.normalize(prefix), offset, limit)
+}
+
+func (s *SuggestionsMap) Len() int {
+	return len(s.current().data)
+}
+
+func (s *SuggestionsMap) Loaded() bool {
+	return s.idx.Load() != nil
+}
+
+// models
+
+type Suggestion struct {
+	Text     string   `json:"text"`
+	Position int      `json:"position"`
+	Cost     *int     `json:"cost,omitempty"`
+	Category *string  `json:"category,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+
+	Highlighted *string         `json:"highlighted,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+
+	// debug only
+	MatchedKey *string `json:"matched_key,omitempty"`
+	MatchType  *string `json:"match_type,omitempty"`
+}
+
+type suggestionDTO struct {
+	ID       string          `json:"id"`
+	Cost     int             `json:"cost"`
+	Name     string          `json:"name"`
+	Category string          `json:"category"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// utils
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type dataReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *dataReader) Close() error {
+	if zr, ok := r.Reader.(*gzip.Reader); ok {
+		zr.Close()
+	}
+
+	return r.file.Close()
+}
+
+// dataFiles expands a comma-separated list of files and glob patterns. A
+// pattern matching nothing is kept as is for opening it to report the error.
+func dataFiles(spec string) ([]string, error) {
+	files := make([]string, 0)
+	for _, pattern := range SplitList(spec) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err)
+		}
+
+		if len(matches) == 0 {
+			matches = []string{pattern}
+		}
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no data files given")
+	}
+
+	return files, nil
+}
+
+func openData(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompress(f, strings.HasSuffix(path, ".gz"))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return &dataReader{Reader: r, file: f}, nil
+}
+
+// decompress unwraps gzipped data, detected by its magic bytes unless gz
+// already says so.
+func decompress(r io.Reader, gz bool) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); !gz && !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+
+	return gzip.NewReader(br)
+}
+
+func SplitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// foldDiacritics strips combining marks after NFD decomposition, so that
+// "é" matches "e" and "й" matches "и".
+func foldDiacritics(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+
+	return folded
+}
+
+// Find returns the byte spans of the non-overlapping occurrences of query in
+// text, comparing both ignoring case with whitespace collapsed and, with
+// fold, diacritics removed the way keys are normalized. The spans refer to
+// the original text, so a match can be highlighted in it.
+func Find(text, query string, fold bool) [][2]int {
+	chars := foldChars(text, fold)
+	q := foldChars(strings.Join(strings.Fields(query), " "), fold)
+	if len(q) == 0 {
+		return nil
+	}
+
+	var spans [][2]int
+	for i := 0; i+len(q) <= len(chars); {
+		if !sameRunes(chars[i:i+len(q)], q) {
+			i++
+			continue
+		}
+
+		spans = append(spans, [2]int{chars[i].start, chars[i+len(q)-1].end})
+		i += len(q)
+	}
+
+	return spans
+}
+
+// foldedChar is a rune of folded text along with the bytes of the original
+// text it was folded from.
+type foldedChar struct {
+	r          rune
+	start, end int
+}
+
+// foldChars lower-cases text rune by rune, collapsing every run of
+// whitespace to a single space and, with fold, removing diacritics. Removed
+// marks are counted in the bytes of the rune before them.
+func foldChars(text string, fold bool) []foldedChar {
+	var chars []foldedChar
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		n := len(chars)
+		switch {
+		case unicode.IsSpace(r) && n > 0 && chars[n-1].r == ' ' && chars[n-1].end == i:
+			chars[n-1].end = i + size
+		case unicode.IsSpace(r):
+			chars = append(chars, foldedChar{' ', i, i + size})
+		case fold:
+			folded := foldDiacritics(string(r))
+			if folded == "" && n > 0 {
+				chars[n-1].end = i + size
+			}
+			for _, f := range folded {
+				chars = append(chars, foldedChar{unicode.ToLower(f), i, i + size})
+			}
+		default:
+			chars = append(chars, foldedChar{unicode.ToLower(r), i, i + size})
+		}
+		i += size
+	}
+
+	return chars
+}
+
+func sameRunes(a, b []foldedChar) bool {
+	for i := range a {
+		if a[i].r != b[i].r {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FuzzyMatch returns the Levenshtein distance between a and b.
+func FuzzyMatch(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// FuzzyPrefixMatch returns the weighted edit distance between query and the
+// closest prefix of key. An edit at the i-th character of query costs
+// 1+(firstChar-1)/(i+1), so mistakes in the first characters weigh more.
+// The scan stops early once the distance is known to exceed limit.
+func FuzzyPrefixMatch(query, key string, firstChar, limit float64) float64 {
+	rq, rk := []rune(query), []rune(key)
+	weight := func(i int) float64 {
+		if i >= len(rq) {
+			i = len(rq) - 1
+		}
+		return 1 + (firstChar-1)/float64(i+1)
+	}
+
+	// prev[j] is the distance between the first i runes of query and the
+	// first j runes of key
+	prev := make([]float64, len(rk)+1)
+	curr := make([]float64, len(rk)+1)
+	for j := 1; j <= len(rk); j++ {
+		prev[j] = prev[j-1] + weight(0)
+	}
+
+	for i := 1; i <= len(rq); i++ {
+		w := weight(i - 1)
+		curr[0] = prev[0] + w
+		best := curr[0]
+		for j := 1; j <= len(rk); j++ {
+			sub := prev[j-1]
+			if rq[i-1] != rk[j-1] {
+				sub += w
+			}
+
+			curr[j] = math.Min(sub, math.Min(prev[j]+w, curr[j-1]+weight(i)))
+			best = math.Min(best, curr[j])
+		}
+
+		if best > limit {
+			return best
+		}
+		prev, curr = curr, prev
+	}
+
+	best := prev[0]
+	for _, d := range prev[1:] {
+		best = math.Min(best, d)
+	}
+
+	return best
+}
+
+// cancelled reports whether ctx is done, checking only every 1024th
+// iteration i of a scanning loop to keep the overhead negligible.
+func cancelled(ctx context.Context, i int) bool {
+	return i&1023 == 0 && ctx.Err() != nil
+}
@@ -0,0 +1,735 @@
+package suggest
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeData writes data to a file in a temporary directory and returns its
+// path.
+func writeData(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// load returns a map with opts and the JSON data loaded.
+func load(t *testing.T, opts MapOptions, data string) *SuggestionsMap {
+	t.Helper()
+
+	s := New(opts)
+	if err := s.Load(writeData(t, "data.json", data)); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func texts(list []Suggestion) []string {
+	texts := make([]string, 0, len(list))
+	for _, suggestion := range list {
+		texts = append(texts, suggestion.Text)
+	}
+
+	return texts
+}
+
+const mixedCase = `[
+	{"id": "IPhone", "name": "IPhone 15 Pro", "cost": 10},
+	{"id": "iphone", "name": "iPhone case", "cost": 20},
+	{"id": "Samsung", "name": "Samsung Galaxy", "cost": 10}
+]`
+
+func TestCaseInsensitive(t *testing.T) {
+	s := load(t, DefaultOptions(), mixedCase)
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"iphone", []string{"IPhone 15 Pro", "iPhone case"}},
+		{"IPHONE", []string{"IPhone 15 Pro", "iPhone case"}},
+		{"iPh", []string{"IPhone 15 Pro", "iPhone case"}},
+		{"sAmSuNg", []string{"Samsung Galaxy"}},
+		{"nokia", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := texts(s.ListByKey(context.Background(), tt.input, ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListByKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CaseInsensitive = false
+	s := load(t, opts, mixedCase)
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"IPhone", []string{"IPhone 15 Pro"}},
+		{"iphone", []string{"iPhone case"}},
+		{"IPHONE", []string{}},
+		{"samsung", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := texts(s.ListByKey(context.Background(), tt.input, ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListByKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// benchData writes n keys of a few items each to a file and returns its path.
+func benchData(b *testing.B, n int) string {
+	b.Helper()
+
+	var data strings.Builder
+	data.WriteString("[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			data.WriteString(",")
+		}
+		for j := 0; j < 3; j++ {
+			if j > 0 {
+				data.WriteString(",")
+			}
+			fmt.Fprintf(&data, `{"id": "key %d", "name": "item %d %d", "cost": %d}`, i, i, j, (i*7+j)%100)
+		}
+	}
+	data.WriteString("]")
+
+	path := filepath.Join(b.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte(data.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	return path
+}
+
+// benchMap returns a map loaded with n keys of a few items each.
+func benchMap(b *testing.B, opts MapOptions, n int) *SuggestionsMap {
+	b.Helper()
+
+	s := New(opts)
+	if err := s.Load(benchData(b, n)); err != nil {
+		b.Fatal(err)
+	}
+
+	return s
+}
+
+// BenchmarkListByKeyParallel contrasts lookups from concurrent goroutines
+// with the same lookups serialized by a mutex, as they were before reads
+// stopped locking each other out.
+func BenchmarkListByKeyParallel(b *testing.B) {
+	opts := DefaultOptions()
+	opts.Mode = MatchExact
+	s := benchMap(b, opts, 10000)
+
+	lookup := func(i int) {
+		s.ListByKey(context.Background(), "key "+strconv.Itoa(i%10000), ListOptions{})
+	}
+
+	b.Run("shared", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				lookup(i)
+			}
+		})
+	})
+
+	b.Run("serialized", func(b *testing.B) {
+		var mx sync.Mutex
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				mx.Lock()
+				lookup(i)
+				mx.Unlock()
+			}
+		})
+	})
+}
+
+func TestLoadTruncated(t *testing.T) {
+	s := load(t, DefaultOptions(), mixedCase)
+
+	// cut off in the middle of the second item
+	truncated := writeData(t, "truncated.json", mixedCase[:strings.Index(mixedCase, "iPhone case")])
+	if err := s.Load(truncated); err == nil {
+		t.Fatal("truncated file loaded")
+	}
+
+	if got, want := texts(s.ListByKey(context.Background(), "iphone", ListOptions{})), []string{"IPhone 15 Pro", "iPhone case"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a failed load: %q, want the previous %q", got, want)
+	}
+	if s.Len() != 2 {
+		t.Errorf("after a failed load: %d keys, want 2", s.Len())
+	}
+}
+
+func TestEqualCostOrder(t *testing.T) {
+	const data = `[
+		{"id": "tv", "name": "tv stand", "cost": 5, "payload": 1},
+		{"id": "tv", "name": "tv box", "cost": 5},
+		{"id": "tv", "name": "tv stand", "cost": 5, "payload": 2},
+		{"id": "tv", "name": "tv", "cost": 1},
+		{"id": "tv", "name": "tv stand", "cost": 5, "payload": 3}
+	]`
+	path := writeData(t, "data.json", data)
+
+	s := New(DefaultOptions())
+	for i := 0; i < 3; i++ {
+		if err := s.Load(path); err != nil {
+			t.Fatal(err)
+		}
+
+		// ties go by name, fully equal items keep the file order
+		var got []string
+		for _, item := range s.Items(context.Background(), "tv") {
+			got = append(got, item.Name+" "+string(item.Payload))
+		}
+		want := []string{"tv ", "tv box ", "tv stand 1", "tv stand 2", "tv stand 3"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("load %d: %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	const data = `[
+		{"id": "phone", "name": "Phone X", "cost": 30, "category": "refurbished"},
+		{"id": "phone", "name": "Phone X", "cost": 10, "category": "new"},
+		{"id": "phones", "name": "phone x", "cost": 20},
+		{"id": "phone", "name": "Phone Y", "cost": 40}
+	]`
+
+	tests := []struct {
+		sort SortOrder
+		want []string
+	}{
+		{SortAsc, []string{"Phone X new", "Phone Y "}},
+		{SortDesc, []string{"Phone Y ", "Phone X refurbished"}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Sort = tt.sort
+		s := load(t, opts, data)
+
+		var got []string
+		for _, suggestion := range s.ListByKey(context.Background(), "Phone", ListOptions{}) {
+			got = append(got, suggestion.Text+" "+*suggestion.Category)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort %s: %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+
+	// texts differing in case are distinct when case matters
+	opts := DefaultOptions()
+	opts.CaseInsensitive = false
+	s := load(t, opts, data)
+	if got, want := texts(s.ListByKey(context.Background(), "phone", ListOptions{})), []string{"Phone X", "phone x", "Phone Y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("case-sensitive: %q, want %q", got, want)
+	}
+}
+
+func TestFoldDiacritics(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ёлка", "елка"},
+		{"Ёж", "Еж"},
+		{"йогурт", "иогурт"},
+		{"café", "cafe"},
+		{"crème brûlée", "creme brulee"},
+		{"Ångström", "Angstrom"},
+		{"jalapeño", "jalapeno"},
+		{"über", "uber"},
+		{"plain", "plain"},
+	}
+
+	for _, tt := range tests {
+		if got := foldDiacritics(tt.in); got != tt.want {
+			t.Errorf("foldDiacritics(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFoldDiacriticsLookup(t *testing.T) {
+	const data = `[
+		{"id": "ёлка", "name": "Ёлка искусственная", "cost": 10},
+		{"id": "cafe", "name": "Café table", "cost": 10}
+	]`
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"елка", []string{"Ёлка искусственная"}},
+		{"Ёлк", []string{"Ёлка искусственная"}},
+		{"café", []string{"Café table"}},
+		{"CAFE", []string{"Café table"}},
+	}
+
+	opts := DefaultOptions()
+	opts.FoldDiacritics = true
+	s := load(t, opts, data)
+	for _, tt := range tests {
+		if got := texts(s.ListByKey(context.Background(), tt.input, ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListByKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// accents matter unless folded
+	s = load(t, DefaultOptions(), data)
+	if got := s.ListByKey(context.Background(), "елка", ListOptions{}); len(got) != 0 {
+		t.Errorf("without folding: %q matched елка", texts(got))
+	}
+}
+
+func TestReloadModes(t *testing.T) {
+	const base = `[
+		{"id": "tv", "name": "tv stand", "cost": 30},
+		{"id": "tv", "name": "tv box", "cost": 20},
+		{"id": "radio", "name": "radio", "cost": 10}
+	]`
+	// updates tv box, adds a tv item and a key
+	const delta = `[
+		{"id": "tv", "name": "tv box", "cost": 40},
+		{"id": "tv", "name": "tv mount", "cost": 5},
+		{"id": "phone", "name": "phone", "cost": 10}
+	]`
+
+	tests := []struct {
+		mode  ReloadMode
+		tv    []string
+		radio []string
+		keys  int
+	}{
+		{ReloadReplace, []string{"tv mount", "tv box"}, []string{}, 2},
+		{ReloadMerge, []string{"tv mount", "tv stand", "tv box"}, []string{"radio"}, 3},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Reload = tt.mode
+		s := load(t, opts, base)
+
+		path := writeData(t, "delta.json", delta)
+		// applying the same delta twice changes nothing more
+		for i := 0; i < 2; i++ {
+			if err := s.Load(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := texts(s.ListByKey(context.Background(), "tv", ListOptions{})); !reflect.DeepEqual(got, tt.tv) {
+			t.Errorf("%s: tv %q, want %q", tt.mode, got, tt.tv)
+		}
+		if got := texts(s.ListByKey(context.Background(), "radio", ListOptions{})); !reflect.DeepEqual(got, tt.radio) {
+			t.Errorf("%s: radio %q, want %q", tt.mode, got, tt.radio)
+		}
+		if s.Len() != tt.keys {
+			t.Errorf("%s: %d keys, want %d", tt.mode, s.Len(), tt.keys)
+		}
+	}
+}
+
+// cancelAfter is done once Err has been called n times, so that it cancels a
+// scan after the first check.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+func TestCancelMidScan(t *testing.T) {
+	var data strings.Builder
+	data.WriteString("[")
+	for i := 0; i < 3000; i++ {
+		if i > 0 {
+			data.WriteString(",")
+		}
+		fmt.Fprintf(&data, `{"id": "key %04d", "name": "item %04d", "cost": %d}`, i, i, i)
+	}
+	data.WriteString("]")
+
+	opts := DefaultOptions()
+	opts.FuzzyDistance = 1
+	opts.CacheSize = 10
+	s := load(t, opts, data.String())
+	idx := s.current()
+
+	if len(s.fuzzyKeys(context.Background(), idx, "key 0001")) == 0 {
+		t.Fatal("no fuzzy keys without cancelling")
+	}
+	if keys := s.fuzzyKeys(&cancelAfter{context.Background(), 1}, idx, "key 0001"); keys != nil {
+		t.Errorf("fuzzyKeys cancelled mid-scan: %d keys", len(keys))
+	}
+
+	// a trigram query and a scan for one too short to have trigrams
+	for _, query := range []string{"item", "it"} {
+		if len(idx.texts.search(context.Background(), query)) != 3000 {
+			t.Fatalf("search(%q) incomplete without cancelling", query)
+		}
+		if items := idx.texts.search(&cancelAfter{context.Background(), 1}, query); len(items) != 0 {
+			t.Errorf("search(%q) cancelled mid-scan: %d items", query, len(items))
+		}
+	}
+
+	// results of a cancelled query aren't cached
+	if list := s.ListByKey(&cancelAfter{context.Background(), 1}, "kez 0001", ListOptions{}); len(list) != 0 {
+		t.Errorf("cancelled fuzzy query: %d suggestions", len(list))
+	}
+	if list := s.ListByKey(context.Background(), "kez 0001", ListOptions{}); len(list) == 0 {
+		t.Error("empty result of a cancelled query cached")
+	}
+}
+
+func TestTieBreakShuffled(t *testing.T) {
+	items := []string{
+		`{"id": "tv c", "name": "box", "cost": 5}`,
+		`{"id": "tv a", "name": "stand", "cost": 5}`,
+		`{"id": "tv b", "name": "arm", "cost": 5}`,
+		`{"id": "tv a", "name": "mount", "cost": 5}`,
+		`{"id": "tv d", "name": "tv", "cost": 1}`,
+	}
+
+	tests := []struct {
+		tieBreak TieBreak
+		want     []string
+	}{
+		{TieBreakName, []string{"tv", "arm", "box", "mount", "stand"}},
+		{TieBreakID, []string{"tv", "mount", "stand", "arm", "box"}},
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.TieBreak = tt.tieBreak
+		s := New(opts)
+
+		for i := 0; i < 20; i++ {
+			rnd.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+			if err := s.Load(writeData(t, "data.json", "["+strings.Join(items, ",")+"]")); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := texts(s.ListByKey(context.Background(), "tv", ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s, shuffle %d: %q, want %q", tt.tieBreak, i, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFuzzyPrefixMatch(t *testing.T) {
+	tests := []struct {
+		query, key  string
+		levenshtein int
+		prefix      float64
+	}{
+		{"apple", "apple", 0, 0},
+		// the same single edit weighs more the earlier it is
+		{"apple", "bpple", 1, 2},
+		{"apple", "applr", 1, 1.2},
+		// a prefix of the key is a match, whatever follows it
+		{"samsing", "samsung galaxy", 8, 1.2},
+		{"samsing", "zamsung", 2, 3.2},
+	}
+
+	for _, tt := range tests {
+		if got := FuzzyMatch(tt.query, tt.key); got != tt.levenshtein {
+			t.Errorf("FuzzyMatch(%q, %q) = %d, want %d", tt.query, tt.key, got, tt.levenshtein)
+		}
+		if got := FuzzyPrefixMatch(tt.query, tt.key, 2, 10); math.Abs(got-tt.prefix) > 1e-9 {
+			t.Errorf("FuzzyPrefixMatch(%q, %q) = %v, want %v", tt.query, tt.key, got, tt.prefix)
+		}
+	}
+}
+
+func TestFuzzyOrdering(t *testing.T) {
+	const data = `[
+		{"id": "bpple", "name": "bpple", "cost": 1},
+		{"id": "applr", "name": "applr", "cost": 50},
+		{"id": "apble pie", "name": "apble pie", "cost": 10}
+	]`
+
+	tests := []struct {
+		name   string
+		prefix bool
+		want   []string
+	}{
+		// equally distant keys rank by cost, a longer key is too far
+		{"levenshtein", false, []string{"bpple", "applr"}},
+		// a late typo beats an early one, whatever follows the prefix
+		{"prefix", true, []string{"applr", "apble pie", "bpple"}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.FuzzyDistance = 2
+		opts.FuzzyPrefix.Enabled = tt.prefix
+		s := load(t, opts, data)
+
+		if got := texts(s.ListByKey(context.Background(), "apple", ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+const catalog = `[
+	{"id": "hello", "name": "hello", "cost": 10},
+	{"id": "hello world", "name": "hello world", "cost": 70},
+	{"id": "help", "name": "help", "cost": 20},
+	{"id": "othello", "name": "othello", "cost": 30}
+]`
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		mode  MatchMode
+		fuzzy int
+		input string
+		want  []string
+	}{
+		{MatchExact, 0, "hello", []string{"hello"}},
+		{MatchExact, 0, "hel", []string{}},
+		{MatchPrefix, 0, "hel", []string{"hello", "help", "hello world"}},
+		{MatchPrefix, 0, "ello", []string{}},
+		{MatchSubstring, 0, "ello", []string{"hello", "othello", "hello world"}},
+		{MatchSubstring, 0, "xyz", []string{}},
+		// fuzzy only kicks in without a match
+		{MatchExact, 1, "helo", []string{"hello", "help"}},
+		{MatchExact, 1, "help", []string{"help"}},
+		{MatchPrefix, 1, "hxlp", []string{"help"}},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Mode, opts.FuzzyDistance = tt.mode, tt.fuzzy
+
+		var store Store = New(opts)
+		if store.Loaded() {
+			t.Fatal("loaded before Load")
+		}
+		if err := store.Load(writeData(t, "data.json", catalog)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := texts(store.ListByKey(context.Background(), tt.input, ListOptions{})); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s, fuzzy %d: ListByKey(%q) = %q, want %q", tt.mode, tt.fuzzy, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestModeOverride(t *testing.T) {
+	s := load(t, DefaultOptions(), catalog)
+
+	if got, want := texts(s.ListByKey(context.Background(), "ello", ListOptions{Mode: MatchSubstring})), []string{"hello", "othello", "hello world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("substring override: %q, want %q", got, want)
+	}
+	if got, want := texts(s.ListByKey(context.Background(), "hello", ListOptions{Mode: MatchExact})), []string{"hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exact override: %q, want %q", got, want)
+	}
+}
+
+func TestLoadFailureKeepsData(t *testing.T) {
+	s := load(t, DefaultOptions(), catalog)
+
+	for name, path := range map[string]string{
+		"missing file": filepath.Join(t.TempDir(), "missing.json"),
+		"invalid json": writeData(t, "invalid.json", `{"id": "hello"}`),
+		"no files":     filepath.Join(t.TempDir(), "*.json"),
+	} {
+		if err := s.Load(path); err == nil {
+			t.Fatalf("%s: loaded", name)
+		}
+
+		if got, want := texts(s.ListByKey(context.Background(), "hel", ListOptions{})), []string{"hello", "help", "hello world"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %q, want the previous %q", name, got, want)
+		}
+		if stats := s.Stats(); stats.Keys != 4 || !stats.Failed || stats.LastError == "" {
+			t.Errorf("%s: stats %+v", name, stats)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	s := New(DefaultOptions())
+
+	tests := []struct {
+		in, want string
+	}{
+		{"iphone  13", "iphone 13"},
+		{"iphone\t13", "iphone 13"},
+		{" \t iphone 13 \n", "iphone 13"},
+		{"iPhone \t\t 13  Pro", "iphone 13 pro"},
+		{"   ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := s.normalize(tt.in); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWhitespaceLookup(t *testing.T) {
+	s := load(t, DefaultOptions(), `[{"id": " iphone\t 13  ", "name": "iPhone  13", "cost": 10}]`)
+
+	for _, input := range []string{"iphone 13", "iphone  13", "\tiphone\t13 ", "iphone 1"} {
+		// the text keeps its spacing
+		if got, want := texts(s.ListByKey(context.Background(), input, ListOptions{})), []string{"iPhone  13"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ListByKey(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// BenchmarkListByKeyDuringReload measures lookups while the data reloads in
+// a loop. The rwmutex case emulates the store before the index was published
+// atomically: lookups held a read lock and every swap took the write lock,
+// which also stalls the lookups arriving while it waits for the running ones.
+func BenchmarkListByKeyDuringReload(b *testing.B) {
+	opts := DefaultOptions()
+	opts.Mode = MatchExact
+
+	for _, locked := range []bool{false, true} {
+		name := "atomic"
+		if locked {
+			name = "rwmutex"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			path := benchData(b, 20000)
+			s := New(opts)
+			if err := s.Load(path); err != nil {
+				b.Fatal(err)
+			}
+			var mx sync.RWMutex
+
+			done := make(chan struct{})
+			reloaded := make(chan struct{})
+			go func() {
+				defer close(reloaded)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					if err := s.Load(path); err != nil {
+						b.Error(err)
+						return
+					}
+					if locked {
+						mx.Lock()
+						mx.Unlock()
+					}
+				}
+			}()
+
+			var latenciesMx sync.Mutex
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				local := make([]time.Duration, 0, 1024)
+				for i := 0; pb.Next(); i++ {
+					start := time.Now()
+					if locked {
+						mx.RLock()
+					}
+					s.ListByKey(context.Background(), "key "+strconv.Itoa(i%20000), ListOptions{})
+					if locked {
+						mx.RUnlock()
+					}
+					local = append(local, time.Since(start))
+				}
+
+				latenciesMx.Lock()
+				latencies = append(latencies, local...)
+				latenciesMx.Unlock()
+			})
+			b.StopTimer()
+			close(done)
+			<-reloaded
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()), "p50-ns")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+		})
+	}
+}
+
+func TestLoadSnapshotBadKeyCount(t *testing.T) {
+	s := New(MapOptions{})
+	for _, keys := range []int{-1, math.MaxInt} {
+		var b strings.Builder
+		header := snapshotHeader{Version: snapshotVersion, Normalization: s.normalization(), Keys: keys}
+		if err := gob.NewEncoder(&b).Encode(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.LoadSnapshot(writeData(t, "snapshot.gob", b.String())); err == nil {
+			t.Errorf("snapshot with %d keys loaded, want an error", keys)
+		}
+	}
+	if s.Loaded() {
+		t.Error("store loaded a bad snapshot")
+	}
+}
+
+func TestValidateConflicts(t *testing.T) {
+	const data = `[
+		{"id": "tv", "name": "tv", "cost": 10},
+		{"id": "tv", "name": "tv", "cost": 20},
+		{"id": "tv", "name": "tv box", "cost": 5}
+	]`
+
+	tests := []struct {
+		policy           ConflictPolicy
+		items, conflicts int
+	}{
+		{ConflictAppend, 3, 0},
+		{ConflictKeepFirst, 2, 1},
+		{ConflictSumCost, 2, 1},
+	}
+
+	for _, tt := range tests {
+		report := Validate(strings.NewReader(data), false, MapOptions{Conflicts: tt.policy})
+		if !report.Valid || report.Items != tt.items || report.Conflicts != tt.conflicts {
+			t.Errorf("%s: report %+v, want %d items and %d conflicts", tt.policy, report, tt.items, tt.conflicts)
+		}
+		if !reflect.DeepEqual(report.DuplicateIDs, []string{"tv"}) {
+			t.Errorf("%s: duplicate ids %q, want [tv]", tt.policy, report.DuplicateIDs)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package suggest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// maxVariants caps the lookups a single query expands into.
+const maxVariants = 16
+
+// Synonyms maps normalized terms to the terms a query containing them is
+// also looked up with. A mapping is one-way; equivalence groups are stored
+// as a mapping from every member to all the others.
+type Synonyms map[string][]string
+
+// loadSynonyms reads either a JSON object of one-way mappings, e.g.
+// {"mobile": ["phone", "cell"]}, or a JSON array of groups of mutually
+// equivalent terms, e.g. [["mobile", "phone", "cell"]]. Terms are normalized
+// like keys and may have several words.
+func loadSynonyms(path string, normalize func(string) string) (Synonyms, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	synonyms := make(Synonyms)
+	add := func(term string, equivalents []string) {
+		term = normalize(term)
+		for _, eq := range equivalents {
+			if eq = normalize(eq); eq != "" && eq != term && !contains(synonyms[term], eq) {
+				synonyms[term] = append(synonyms[term], eq)
+			}
+		}
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var groups [][]string
+		if err := json.Unmarshal(data, &groups); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		for _, group := range groups {
+			for _, term := range group {
+				add(term, group)
+			}
+		}
+	} else {
+		var mappings map[string][]string
+		if err := json.Unmarshal(data, &mappings); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		for term, equivalents := range mappings {
+			add(term, equivalents)
+		}
+	}
+	delete(synonyms, "")
+
+	return synonyms, nil
+}
+
+// expand returns key followed by the variants with synonyms substituted for
+// the whole-word terms key contains. Substituted terms aren't expanded
+// again, so mappings never chain.
+func (s Synonyms) expand(key string) []string {
+	variants := []string{key}
+	if len(s) == 0 {
+		return variants
+	}
+
+	terms := make([]string, 0)
+	for term := range s {
+		if strings.Contains(" "+key+" ", " "+term+" ") {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+
+	for _, term := range terms {
+		for _, variant := range variants {
+			if !strings.Contains(" "+variant+" ", " "+term+" ") {
+				continue
+			}
+
+			for _, eq := range s[term] {
+				if len(variants) == maxVariants {
+					return variants
+				}
+
+				replaced := strings.ReplaceAll(" "+variant+" ", " "+term+" ", " "+eq+" ")
+				if replaced = strings.TrimSpace(replaced); !contains(variants, replaced) {
+					variants = append(variants, replaced)
+				}
+			}
+		}
+	}
+
+	return variants
+}
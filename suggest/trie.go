@@ -0,0 +1,117 @@
+package suggest
+
+import "sort"
+
+// Trie indexes keys rune by rune so that a prefix lookup costs the length of
+// the prefix plus the size of the matching subtree. Every node holds the
+// ranked items of the key ending at it. Children are kept in a slice sorted
+// by rune, which is leaner than a map and yields keys in lexicographic order,
+// so the trie also serves key listings instead of a sorted copy of the keys.
+type Trie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	children []trieEdge
+	items    []Item
+}
+
+type trieEdge struct {
+	r    rune
+	node *trieNode
+}
+
+func NewTrie() *Trie {
+	return &Trie{}
+}
+
+func (t *Trie) Insert(key string, items []Item) {
+	node := &t.root
+	for _, r := range key {
+		node = node.child(r, true)
+	}
+
+	if node.items == nil {
+		node.items = items
+		return
+	}
+	node.items = append(node.items, items...)
+}
+
+// Prefix returns the items of every key starting with prefix, grouped by key
+// in lexicographic order. It takes no limit: the items of all the keys are
+// ranked together, and the total, filters, dedup, offsets and the cache all
+// need the full list.
+func (t *Trie) Prefix(prefix string) []Item {
+	node := &t.root
+	for _, r := range prefix {
+		if node = node.child(r, false); node == nil {
+			return []Item{}
+		}
+	}
+
+	items := make([]Item, 0)
+	node.collect(&items)
+
+	return items
+}
+
+// Keys returns the keys starting with prefix in lexicographic order, skipping
+// offset of them and returning at most limit, along with their total.
+func (t *Trie) Keys(prefix string, offset, limit int) ([]string, int) {
+	node := &t.root
+	for _, r := range prefix {
+		if node = node.child(r, false); node == nil {
+			return []string{}, 0
+		}
+	}
+
+	keys := make([]string, 0)
+	total := 0
+	node.walk([]rune(prefix), func(key []rune) {
+		if total >= offset && len(keys) < limit {
+			keys = append(keys, string(key))
+		}
+		total++
+	})
+
+	return keys, total
+}
+
+func (n *trieNode) child(r rune, create bool) *trieNode {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].r >= r
+	})
+	if i < len(n.children) && n.children[i].r == r {
+		return n.children[i].node
+	}
+
+	if !create {
+		return nil
+	}
+
+	child := &trieNode{}
+	n.children = append(n.children, trieEdge{})
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = trieEdge{r: r, node: child}
+
+	return child
+}
+
+func (n *trieNode) collect(items *[]Item) {
+	*items = append(*items, n.items...)
+	for _, edge := range n.children {
+		edge.node.collect(items)
+	}
+}
+
+// walk calls visit with every key ending in the subtree of n, whose own key
+// is path. The slice passed is only valid during the call.
+func (n *trieNode) walk(path []rune, visit func(key []rune)) {
+	if len(n.items) > 0 {
+		visit(path)
+	}
+	for _, edge := range n.children {
+		edge.node.walk(append(path, edge.r), visit)
+	}
+}
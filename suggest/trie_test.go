@@ -0,0 +1,105 @@
+package suggest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestTrie(keys ...string) *Trie {
+	t := NewTrie()
+	for _, key := range keys {
+		t.Insert(key, []Item{{Key: key, Name: key}})
+	}
+
+	return t
+}
+
+func TestTriePrefix(t *testing.T) {
+	trie := newTestTrie("hello", "he", "help", "ёлка", "hi")
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"he", []string{"he", "hello", "help"}},
+		{"hel", []string{"hello", "help"}},
+		{"h", []string{"he", "hello", "help", "hi"}},
+		{"ёл", []string{"ёлка"}},
+		{"x", []string{}},
+		{"helpme", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := make([]string, 0)
+		for _, item := range trie.Prefix(tt.prefix) {
+			got = append(got, item.Key)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Prefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestTrieKeys(t *testing.T) {
+	trie := newTestTrie("hello", "he", "help", "ёлка", "hi")
+
+	tests := []struct {
+		prefix        string
+		offset, limit int
+		want          []string
+		total         int
+	}{
+		{"", 0, 10, []string{"he", "hello", "help", "hi", "ёлка"}, 5},
+		{"he", 0, 10, []string{"he", "hello", "help"}, 3},
+		{"he", 1, 1, []string{"hello"}, 3},
+		{"he", 3, 10, []string{}, 3},
+		{"h", 0, 0, []string{}, 4},
+		{"ё", 0, 10, []string{"ёлка"}, 1},
+		{"x", 0, 10, []string{}, 0},
+	}
+
+	for _, tt := range tests {
+		got, total := trie.Keys(tt.prefix, tt.offset, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) || total != tt.total {
+			t.Errorf("Keys(%q, %d, %d) = %q, %d, want %q, %d", tt.prefix, tt.offset, tt.limit, got, total, tt.want, tt.total)
+		}
+	}
+}
+
+// BenchmarkListByPrefix contrasts the trie with scanning the keys of the
+// data map, which is what a prefix lookup costs without it.
+func BenchmarkListByPrefix(b *testing.B) {
+	data := make(map[string][]Item, 100000)
+	trie := NewTrie()
+	keys := make([]string, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		keys = append(keys, fmt.Sprintf("key %05d", i))
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data[key] = []Item{{Key: key, Name: key}}
+		trie.Insert(key, data[key])
+	}
+
+	for _, prefix := range []string{"key 0123", "key 01", "key 9"} {
+		b.Run("trie/"+prefix, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.Prefix(prefix)
+			}
+		})
+
+		b.Run("scan/"+prefix, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				items := make([]Item, 0)
+				for key, list := range data {
+					if strings.HasPrefix(key, prefix) {
+						items = append(items, list...)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package suggest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Report describes a data file checked by Validate.
+type Report struct {
+	Valid bool `json:"valid"`
+	Keys  int  `json:"keys"`
+	Items int  `json:"items"`
+	// ids with several items of the same name, merged by the conflict policy
+	DuplicateIDs []string `json:"duplicate_ids"`
+	// duplicates the conflict policy would merge away, as in the load stats
+	Conflicts int `json:"conflicts"`
+	// malformed CSV rows are skipped by loads, anything else fails them
+	Errors []string `json:"errors"`
+}
+
+// Validate parses a JSON or, with csv, a CSV data file the way Load would
+// with opts, into a throwaway map, resolves its conflicts by the conflict
+// policy, and reports what it would load.
+func Validate(r io.Reader, csv bool, opts MapOptions) Report {
+	report := Report{DuplicateIDs: make([]string, 0), Errors: make([]string, 0)}
+	s := &SuggestionsMap{opts: opts}
+	s.skipped = func(err error) {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	data, err := s.validate(r, csv)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	for _, items := range data {
+		if id, ok := duplicateID(items); ok {
+			report.DuplicateIDs = append(report.DuplicateIDs, id)
+		}
+	}
+	sort.Strings(report.DuplicateIDs)
+
+	// counted after the conflict policy, like a load
+	report.Conflicts = s.resolve(data)
+	report.Keys = len(data)
+	for _, items := range data {
+		report.Items += len(items)
+	}
+
+	if opts.MaxKeys > 0 && report.Keys > opts.MaxKeys {
+		report.Errors = append(report.Errors, fmt.Sprintf("data has %d keys, more than the limit of %d", report.Keys, opts.MaxKeys))
+	}
+	report.Valid = len(report.Errors) == 0
+
+	return report
+}
+
+func (s *SuggestionsMap) validate(r io.Reader, csv bool) (map[string][]Item, error) {
+	r, err := decompress(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if csv {
+		return s.decodeCSV(r)
+	}
+
+	return s.decode(r)
+}
+
+func duplicateID(items []Item) (string, bool) {
+	type pair struct{ id, name string }
+
+	seen := make(map[pair]bool, len(items))
+	for _, item := range items {
+		if seen[pair{item.ID, item.Name}] {
+			return item.ID, true
+		}
+		seen[pair{item.ID, item.Name}] = true
+	}
+
+	return "", false
+}
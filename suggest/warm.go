@@ -0,0 +1,52 @@
+package suggest
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// warm fills the freshly purged cache with the results of the prefixes
+// listed in the WarmPrefixes file, one per line, so that the first queries
+// after a load don't all miss. At most the cache size of prefixes is warmed.
+// Failures are logged and leave the cache cold.
+func (s *SuggestionsMap) warm() {
+	if s.cache == nil || s.opts.WarmPrefixes == "" {
+		return
+	}
+
+	start := time.Now()
+	prefixes, err := readPrefixes(s.opts.WarmPrefixes, s.opts.CacheSize)
+	if err != nil {
+		log.Printf("warning: warming the cache failed: %v", err)
+		return
+	}
+
+	gen := s.cache.generation()
+	for _, prefix := range prefixes {
+		key := s.normalize(prefix)
+		s.cache.add(gen, cacheKey(key, s.opts.Mode, ListOptions{}), s.list(context.Background(), key, s.opts.Mode, ListOptions{}))
+	}
+	metrics.warmup.Set(time.Since(start).Seconds())
+}
+
+func readPrefixes(path string, limit int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	prefixes := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && len(prefixes) < limit {
+		if prefix := strings.TrimSpace(scanner.Text()); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes, scanner.Err()
+}
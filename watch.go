@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"suggestion/suggest"
+)
+
+// watch reloads fname whenever one of its files or of the extra files loaded
+// along with it, such as synonyms, changes. Parent directories
+// are watched rather than the files themselves so that write-temp-and-rename
+// updates, which replace the watched inode, and new files matching a glob
+// pattern are still picked up.
+func watch(ctx context.Context, fname string, extra []string, debounce time.Duration) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	patterns := append(suggest.SplitList(fname), extra...)
+	for i, pattern := range patterns {
+		patterns[i] = filepath.Clean(pattern)
+		if err = w.Add(filepath.Dir(patterns[i])); err != nil {
+			return err
+		}
+	}
+
+	// loadInitial has loaded the data before serving
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.Events:
+			if !ok {
+				return fmt.Errorf("watcher closed")
+			}
+
+			if matchAny(patterns, filepath.Clean(event.Name)) && event.Has(fsnotify.Write|fsnotify.Create) {
+				timer = time.After(debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return fmt.Errorf("watcher closed")
+			}
+			log.Println(err)
+		case <-timer:
+			timer = nil
+			load(fname)
+		}
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}